@@ -2,11 +2,14 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/jpeach/modden/pkg/driver"
 	"github.com/jpeach/modden/pkg/filter"
+	"github.com/jpeach/modden/pkg/fixture"
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/version"
 
@@ -20,8 +23,8 @@ import (
 func NewGetCommand() *cobra.Command {
 	get := &cobra.Command{
 		Use:          "get",
-		Short:        "Gets one of [objects, tests]",
-		Long:         "Gets one of [objects, tests]",
+		Short:        "Gets one of [fixtures, objects]",
+		Long:         "Gets one of [fixtures, objects]",
 		SilenceUsage: true,
 	}
 
@@ -73,6 +76,54 @@ with the %s%s%s label.`,
 		},
 	}
 
+	fixtures := &cobra.Command{
+		Use:   "fixtures [FLAGS ...]",
+		Short: "Gets test fixtures",
+		Long: `Gets the test fixtures loaded from the '--fixtures' flag
+
+This command loads fixtures in the same way as the 'run' command, and
+lists the type and name of each fixture, along with the fixture groups
+that it belongs to and the file that it was loaded from. This can be
+used to check which fixtures a test document can apply.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fixtures, err := loadFixtures(
+				must.StringSlice(cmd.Flags().GetStringSlice("fixtures")))
+			if err != nil {
+				return ExitError{Code: EX_NOINPUT, Err: err}
+			}
+
+			writeFixtures(os.Stdout, fixtures)
+			return nil
+		},
+	}
+
+	fixtures.Flags().StringSlice("fixtures", []string{}, "Kubernetes resource fixtures")
+
+	get.AddCommand(CommandWithDefaults(fixtures))
 	get.AddCommand(CommandWithDefaults(objects))
 	return CommandWithDefaults(get)
 }
+
+// writeFixtures writes a table of the fixtures in the set.
+func writeFixtures(out io.Writer, fixtures fixture.FixtureSet) {
+	entries := fixtures.Entries()
+	if len(entries) == 0 {
+		return
+	}
+
+	table := uitable.New()
+	table.AddRow("APIVERSION", "KIND", "NAMESPACE", "NAME", "GROUPS", "SOURCE")
+
+	for _, e := range entries {
+		table.AddRow(
+			e.Key.APIVersion,
+			e.Key.Kind,
+			e.Key.Namespace,
+			e.Key.Name,
+			strings.Join(e.Groups, ","),
+			e.Source,
+		)
+	}
+
+	fmt.Fprintln(out, table)
+}
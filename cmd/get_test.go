@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/fixture"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFixtures(t *testing.T) {
+	fixtures, err := loadFixtures([]string{"../examples/fixtures"})
+	require.NoError(t, err)
+
+	out := bytes.Buffer{}
+	writeFixtures(&out, fixtures)
+
+	var rows [][]string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		rows = append(rows, strings.Fields(line))
+	}
+
+	source := "../examples/fixtures/httpbin.yaml"
+
+	// Note that the empty namespace column is elided.
+	assert.Equal(t, [][]string{
+		{"APIVERSION", "KIND", "NAMESPACE", "NAME", "GROUPS", "SOURCE"},
+		{"apps/v1", "Deployment", "httpbin", "httpbin", source},
+		{"v1", "Service", "httpbin", "httpbin", source},
+		{"projectcontour.io/v1", "HTTPProxy", "httpbin", "httpbin", source},
+	}, rows)
+
+	// Empty sets write nothing.
+	out.Reset()
+	writeFixtures(&out, fixture.NewSet())
+	assert.Empty(t, out.String())
+}
@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jpeach/modden/pkg/doc"
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/fixture"
+	"github.com/jpeach/modden/pkg/kustomize"
+	"github.com/jpeach/modden/pkg/must"
+	"github.com/jpeach/modden/pkg/test"
+	"github.com/jpeach/modden/pkg/utils"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/spf13/cobra"
+)
+
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+)
+
+// LintProblem describes a problem found in a test document.
+type LintProblem struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, p.Severity, p.Message)
+	}
+
+	return fmt.Sprintf("%s: %s: %s", p.File, p.Severity, p.Message)
+}
+
+// NewLintCommand returns a command to validate test documents.
+func NewLintCommand() *cobra.Command {
+	lint := &cobra.Command{
+		Use:   "lint [FLAGS ...] FILE|DIR [FILE|DIR ...]",
+		Short: "Validate a set of test documents",
+		Long: `Validate a set of test documents given as arguments.
+
+The lint command checks test documents without connecting to a
+Kubernetes cluster. Each document fragment is decoded and any Rego
+checks are compiled, together with the policies given by the
+'--policies' flag. Kubernetes objects are checked for missing
+kind and version fields, unsupported special operations, and
+fixtures that can't be resolved from the '--fixtures' flag.
+Kustomizations are built, and the resulting objects are checked
+in the same way.
+
+Directories are walked for test documents in the same way as the
+run command.
+
+Problems are reported one per line in the "FILE:LINE: SEVERITY: MESSAGE"
+format. The '--format' flag can be set to "json" to emit problems as
+a JSON array instead.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return ExitErrorf(EX_USAGE, "no test file(s)")
+			}
+
+			return lintCmd(cmd, args)
+		},
+	}
+
+	lint.Flags().StringSlice("fixtures", []string{}, "Additional Kubernetes resource fixtures")
+	lint.Flags().StringSlice("policies", []string{}, "Additional Rego policy packages")
+	lint.Flags().String("format", "text", "Lint results output format")
+
+	return CommandWithDefaults(lint)
+}
+
+func lintCmd(cmd *cobra.Command, args []string) error {
+	var policies []*ast.Module
+
+	format := must.String(cmd.Flags().GetString("format"))
+	switch format {
+	case "text", "json":
+	default:
+		return ExitErrorf(EX_USAGE, "invalid lint output format %q", format)
+	}
+
+	fixtures, err := loadFixtures(
+		must.StringSlice(cmd.Flags().GetStringSlice("fixtures")))
+	if err != nil {
+		return ExitError{Code: EX_NOINPUT, Err: err}
+	}
+
+	if paths := must.StringSlice(cmd.Flags().GetStringSlice("policies")); len(paths) > 0 {
+		modules, err := loadPolicies(paths)
+		if err != nil {
+			return ExitError{Code: EX_DATAERR, Err: err}
+		}
+
+		for _, m := range modules {
+			policies = append(policies, m)
+		}
+	}
+
+	paths, err := findDocuments(args,
+		must.StringSlice(cmd.Flags().GetStringSlice("fixtures")))
+	if err != nil {
+		return ExitError{Code: EX_NOINPUT, Err: err}
+	}
+
+	problems := []LintProblem{}
+
+	for _, path := range paths {
+		problems = append(problems, lintDocument(path, fixtures, policies)...)
+	}
+
+	if err := writeLintProblems(os.Stdout, format, problems); err != nil {
+		return err
+	}
+
+	for _, p := range problems {
+		if p.Severity == lintError {
+			return ExitError{Code: EX_DATAERR}
+		}
+	}
+
+	return nil
+}
+
+func writeLintProblems(out io.Writer, format string, problems []LintProblem) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(problems)
+	default:
+		for _, p := range problems {
+			fmt.Fprintln(out, p.String())
+		}
+
+		return nil
+	}
+}
+
+// lintDocument checks all the fragments in the test document at the
+// given path, returning any problems that it finds, ordered by line.
+func lintDocument(path string, fixtures fixture.FixtureSet, policies []*ast.Module) []LintProblem {
+	var problems []LintProblem
+
+	report := func(file string, line int, severity string, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{
+			File:     file,
+			Line:     line,
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Rego error locations refer to the file and line of the
+	// fragment that the error is in.
+	reportRego := func(prefix string, errs ast.Errors) {
+		for _, e := range errs {
+			if e.Location == nil {
+				report(path, 0, lintError, "%s%s: %s", prefix, e.Code, e.Message)
+				continue
+			}
+
+			report(e.Location.File, e.Location.Row, lintError,
+				"%s%s: %s", prefix, e.Code, e.Message)
+		}
+	}
+
+	testDoc, err := doc.ReadFile(path)
+	if err != nil {
+		report(path, 0, lintError, "%s", err)
+		return problems
+	}
+
+	env := driver.NewEnvironment(fixtures)
+
+	for i := range testDoc.Parts {
+		part := &testDoc.Parts[i]
+
+		fragType, err := part.Decode()
+		if err != nil {
+			if regoErr := utils.AsRegoCompilationErr(err); regoErr != nil {
+				reportRego(err.Error()+": ", regoErr)
+				continue
+			}
+
+			report(part.Location.File, part.Location.Start, lintError, "%s", err)
+			continue
+		}
+
+		switch fragType {
+		case doc.FragmentTypeMetadata:
+			if i > 0 {
+				report(part.Location.File, part.Location.Start, lintError,
+					"document metadata must be the first fragment")
+				continue
+			}
+
+			docFixtures, err := test.DocumentFixtures(testDoc, fixtures)
+			if err != nil {
+				report(part.Location.File, part.Location.Start, lintError,
+					"failed to load document fixtures: %s", err)
+				continue
+			}
+
+			env = driver.NewEnvironment(docFixtures)
+		case doc.FragmentTypeUnknown:
+			report(part.Location.File, part.Location.Start, lintWarning,
+				"ignoring fragment of unknown type")
+		case doc.FragmentTypeObject:
+			if _, err := env.HydrateObjectsAt(part.Location, part.Bytes); err != nil {
+				report(part.Location.File, part.Location.Start, lintError, "%s", err)
+			}
+		case doc.FragmentTypeKustomize:
+			dir := part.Kustomize().Dir
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(part.Location.File), dir)
+			}
+
+			nodes, err := kustomize.Build(dir)
+			if err != nil {
+				report(part.Location.File, part.Location.Start, lintError,
+					"failed to build kustomization %q: %s", dir, err)
+				continue
+			}
+
+			for _, n := range nodes {
+				if _, err := env.HydrateObject([]byte(n.MustString())); err != nil {
+					report(part.Location.File, part.Location.Start, lintError, "%s", err)
+				}
+			}
+		}
+	}
+
+	if _, err := test.CompileDocument(testDoc, policies); err != nil {
+		if regoErr := utils.AsRegoCompilationErr(err); regoErr != nil {
+			reportRego("", regoErr)
+		} else {
+			report(path, 0, lintError, "%s", err)
+		}
+	}
+
+	sort.SliceStable(problems, func(i, j int) bool {
+		if problems[i].File != problems[j].File {
+			return problems[i].File < problems[j].File
+		}
+		return problems[i].Line < problems[j].Line
+	})
+
+	return problems
+}
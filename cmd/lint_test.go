@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/fixture"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLintDocument(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: lint
+$aply: delete
+---
+metadata:
+  name: no-kind
+---
+error[msg] {
+  msg := undefined_variable
+}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+`), 0644))
+
+	problems := lintDocument(path, fixture.NewSet(), nil)
+
+	lines := []int{}
+	for _, p := range problems {
+		assert.Equal(t, path, p.File)
+		assert.Equal(t, lintError, p.Severity)
+		lines = append(lines, p.Line)
+	}
+
+	assert.Equal(t, []int{1, 7, 11}, lines)
+
+	assert.Equal(t,
+		[]LintProblem{{File: "missing.yaml", Severity: lintError,
+			Message: "open missing.yaml: no such file or directory"}},
+		lintDocument("missing.yaml", fixture.NewSet(), nil))
+}
+
+func TestLintDocumentFixtures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "fixtures"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fixtures", "config.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`), 0644))
+
+	path := filepath.Join(dir, "test.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`apiVersion: modden/v1alpha1
+kind: Document
+spec:
+  fixtures: [fixtures]
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+$apply: fixture
+`), 0644))
+
+	// The document fixtures resolve the fixture object.
+	assert.Empty(t, lintDocument(path, fixture.NewSet(), nil))
+
+	// Conflicting document fixtures are errors.
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "fixtures", "dup.yaml"), []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  conflict: "true"
+`), 0644))
+
+	problems := lintDocument(path, fixture.NewSet(), nil)
+	require.Len(t, problems, 2)
+	assert.Contains(t, problems[0].Message, "duplicate fixture for v1:ConfigMap 'config'")
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jpeach/modden/pkg/must"
+	"github.com/jpeach/modden/pkg/result"
+	"github.com/jpeach/modden/pkg/test"
+
+	"github.com/spf13/cobra"
+)
+
+// NewReportCommand returns a command to render saved test results.
+func NewReportCommand() *cobra.Command {
+	report := &cobra.Command{
+		Use:   "report [FLAGS ...] FILE [FILE ...]",
+		Short: "Render saved test results",
+		Long: `Render saved test results given as arguments.
+
+The report command reads test results that were saved by the run
+command's '--save' flag, or emitted by its "json" output format, and
+renders them again. If more than one results file is given, the
+results are merged in the order of the arguments. This can be used
+to combine the results of test runs that were split across several
+CI jobs.
+
+The '--format' flag selects the output format. In addition to the
+"tree", "tap" and "json" formats supported by the run command, the
+"html" format renders a self-contained HTML report, and the "junit"
+format renders a JUnit XML report.
+
+The report command exits with an error status if any of the saved
+test documents failed.
+`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return ExitErrorf(EX_USAGE, "no results file(s)")
+			}
+
+			return reportCmd(cmd, args)
+		},
+	}
+
+	report.Flags().String("format", "tree", "Test results output format")
+	report.Flags().BoolP("verbose", "v", false, "Show the informational results of passing steps")
+
+	return CommandWithDefaults(report)
+}
+
+func reportCmd(cmd *cobra.Command, args []string) error {
+	docs, err := readResults(args)
+	if err != nil {
+		return ExitError{Code: EX_NOINPUT, Err: err}
+	}
+
+	switch format := must.String(cmd.Flags().GetString("format")); format {
+	case "html":
+		if err := test.WriteHTMLReport(os.Stdout, docs); err != nil {
+			return err
+		}
+	case "junit":
+		if err := test.WriteJUnitReport(os.Stdout, docs); err != nil {
+			return err
+		}
+	case "json":
+		// Write the documents directly so that the step
+		// timestamps are preserved.
+		if err := test.WriteJSON(os.Stdout, docs); err != nil {
+			return err
+		}
+	default:
+		writer, err := newResultWriter(format,
+			must.Bool(cmd.Flags().GetBool("verbose")))
+		if err != nil {
+			return err
+		}
+
+		test.Replay(writer, docs)
+		writeTimingSummary(format, docs)
+	}
+
+	for _, d := range docs {
+		failed := false
+		d.EachResult(func(s *test.Step, r *result.Result) {
+			if r.IsFailed() {
+				failed = true
+			}
+		})
+
+		if failed {
+			return ExitError{Code: EX_FAIL}
+		}
+	}
+
+	return nil
+}
+
+// readResults reads and merges saved test results from each of
+// the given paths.
+func readResults(paths []string) ([]*test.Document, error) {
+	var docs []*test.Document
+
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+
+		results, err := test.ReadJSON(f)
+		f.Close()
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+
+		docs = append(docs, results...)
+	}
+
+	return docs, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/result"
+	"github.com/jpeach/modden/pkg/test"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadResults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, docs ...string) string {
+		p := filepath.Join(dir, name)
+		f, err := os.Create(p)
+		require.NoError(t, err)
+		defer f.Close()
+
+		var records []*test.Document
+		for _, d := range docs {
+			records = append(records, &test.Document{
+				Description: d,
+				Steps: []*test.Step{{
+					Description: "step",
+					Results:     []result.Result{result.Infof("%s", d)},
+				}},
+			})
+		}
+
+		require.NoError(t, test.WriteJSON(f, records))
+		return p
+	}
+
+	shard1 := write("shard1.json", "a.yaml", "b.yaml")
+	shard2 := write("shard2.json", "c.yaml")
+
+	docs, err := readResults([]string{shard1, shard2})
+	require.NoError(t, err)
+
+	var names []string
+	for _, d := range docs {
+		names = append(names, d.Description)
+	}
+
+	assert.Equal(t, []string{"a.yaml", "b.yaml", "c.yaml"}, names)
+
+	_, err = readResults([]string{shard1, filepath.Join(dir, "missing.json")})
+	assert.Error(t, err)
+}
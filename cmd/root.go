@@ -74,6 +74,8 @@ to quickly create a Cobra application.`,
 
 	root.AddCommand(NewRunCommand())
 	root.AddCommand(NewGetCommand())
+	root.AddCommand(NewLintCommand())
+	root.AddCommand(NewReportCommand())
 
 	return CommandWithDefaults(root)
 }
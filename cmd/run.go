@@ -1,33 +1,54 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/jpeach/modden/pkg/doc"
 	"github.com/jpeach/modden/pkg/driver"
 	"github.com/jpeach/modden/pkg/fixture"
+	"github.com/jpeach/modden/pkg/kustomize"
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/result"
 	"github.com/jpeach/modden/pkg/test"
 	"github.com/jpeach/modden/pkg/utils"
-	"github.com/open-policy-agent/opa/ast"
 
+	"github.com/gosuri/uitable"
+	"github.com/open-policy-agent/opa/ast"
 	"github.com/spf13/cobra"
 )
 
 // NewRunCommand returns a command ro run a test case.
 func NewRunCommand() *cobra.Command {
 	run := &cobra.Command{
-		Use:   "run [FLAGS ...] FILE [FILE ...]",
+		Use:   "run [FLAGS ...] FILE|DIR [FILE|DIR ...]",
 		Short: "Run a set of test documents",
 		Long: `Execute a set of test documents given as arguments.
 
+If a directory is given, modden recursively runs all the test
+documents with a ".yaml", ".yml", ".json", ".md" or ".markdown"
+extension that it contains.
+
 Test documents are ordered fragments of YAML object and Rego checks,
 separated by the YAML document separator, '---'. The fragments in
 the test document are executed sequentially.
 
+Test documents can also be written in Markdown. In a Markdown test
+document, each fenced "yaml", "json" or "rego" code block contains
+test fragments, and all other text is ignored. Code blocks that have
+the 'modden:skip' attribute in their info string are also ignored.
+
+The type of each fragment is guessed from its content. A fragment
+can be explicitly marked as Rego or YAML with a "# modden: rego" or
+"# modden: yaml" comment, either on its first line or following the
+preceding '---' separator. Marked fragments are strictly decoded as
+the marked type.
+
 If a Kubernetes object specifies a target namespace in its metadata,
 modden will implicitly create and manage that namespace. This reduces
 test verbosity be not requiring namespace YAML fragments.
@@ -42,12 +63,47 @@ key has the value 'delete'. If the target object has a name, modden will
 delete that object. Otherwise, modden will attempt to select an object
 to delete by matching the run ID and any specified labels.
 
+If the special '$apply' key has the value 'expect', modden will not
+apply the object. Instead, it waits for the live object in the
+'data.resources' tree to contain all of the fields given in the test
+document. If the object doesn't converge before the check timeout,
+modden reports the difference between the expected and live objects.
+
+A fragment with the special '$kustomize' key builds the kustomization
+in the given directory, and applies each of the resulting objects. The
+directory path is relative to the test document.
+
+A fragment with the special '$exec' key runs a local command. The
+command output and exit status are published to Rego checks as
+'data.exec.last', and all the command results are appended to
+'data.exec.log'. A command that exits with a non-zero status or
+that times out fails the test.
+
 Unless the '--preserve' flag is specified, modden will automatically
 delete all the Kubernetes objects it created at the end of each test.
 
+If the '--artifacts' flag is specified, modden collects debugging
+artifacts when a test document fails. The current and previous
+container logs of each pod created by the test are written to a
+subdirectory of the artifacts directory that is named by the test
+run ID. When a check fails, the adopted Kubernetes objects, the Rego
+data document, the check module and the check input are also written
+to a subdirectory for the failing step.
+
+The '--trace=rego' flag traces the evaluation of every Rego check.
+A Rego check can also be traced individually by adding a "# $trace"
+comment to it, and the check for a Kubernetes object can be traced
+by setting the special '$trace' key to 'true'. Only the trace of the
+last evaluation of a check that fails is kept. The last 50 lines of
+the trace are attached to the failing step, or the full trace is
+written to the failing step's artifacts if the '--artifacts' flag is
+specified.
+
 Since both Kubernetes and the services in a cluster are eventually
 consistent, checks are executed repeatedly until they succeed or
-until the timeout given by the '--check-timeout' flag expires.
+until the timeout given by the '--check-timeout' flag expires. At the
+end of a run, the tree and TAP formats show the slowest steps, and the
+checks that spent the longest polling before they converged.
 
 The '--param' flag can be provided multiple times to add an element
 to the Rego data store. The argument to this flag is a "key=value"
@@ -59,10 +115,53 @@ tree. If a test needs to inspect more resources, the '--watch' flag
 can be provided multiple times to specify additional resource types
 to monitor and publish.
 
+A test document may begin with a metadata fragment of kind 'Document'
+in the 'modden/v1alpha1' API version. This fragment names and describes
+the document, and can specify parameters that must be given with the
+'--param' flag and a check timeout that overrides '--check-timeout'.
+The document metadata is published to Rego checks as 'data.test.document'.
+
+The '--tags' and '--skip-tags' flags select documents by the tags in
+their metadata. Each flag can be given multiple times. A tag expression
+is one or more tags joined by '+', and matches documents that have all
+of the tags. A document runs if it matches any '--tags' expression
+(or if there are none), and it matches no '--skip-tags' expression.
+The '--list' flag prints the selected documents without running them.
+
 The test results output format can be changed by the '--format'
 flag. The default format is 'tree', which is a custom hierarchical
-format suitable for terminals. The "tap" format emits TAP (Test
-Anything Protocol) results.
+format suitable for terminals. When writing to a terminal, the tree
+format is colored and wrapped to the terminal width. Color can be
+disabled by setting the NO_COLOR environment variable. The tree format
+only shows informational messages for steps that fail, unless the
+'--verbose' flag is given. The "tap" format emits TAP (Test
+Anything Protocol) results. The "json" format emits each test document
+as a JSON object on a single line.
+
+The '--save' flag saves the test results as JSON to the given file,
+in addition to the normal output. Saved results can be rendered
+again, in any format, with the 'report' command.
+
+The '--coverage' flag reports the Rego coverage of the checks at the
+end of the run. Coverage is aggregated over every evaluation of every
+check, and is reported for each file loaded with the '--policies'
+flag and for each Rego fragment and object '$check' field in the test
+documents. The "text" coverage format shows the percentage of lines
+covered and the lines that were not covered. The "json" format
+includes the line ranges that were covered and not covered. The
+coverage report is written to standard output, unless the test
+results are in the "json" format, in which case it is written to
+standard error.
+
+The '--html' flag writes a self-contained HTML report of the test
+results to the given file, in addition to the normal output. Any
+artifacts collected with the '--artifacts' flag are embedded in the
+report.
+
+The '--junit' flag writes a JUnit XML report of the test results to
+the given file, in addition to the normal output. Each test document
+is reported as a test suite, with the document metadata as its
+properties, and each step is reported as a test case.
 `,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
@@ -75,6 +174,11 @@ Anything Protocol) results.
 
 	run.Flags().String("trace", "", "Set execution tracing flags")
 	run.Flags().Bool("preserve", false, "Don't automatically delete Kubernetes objects")
+	run.Flags().String("artifacts", "", "Directory to write failure artifacts to")
+	run.Flags().String("html", "", "Write an HTML test report to this file")
+	run.Flags().String("junit", "", "Write a JUnit XML test report to this file")
+	run.Flags().String("save", "", "Save the test results as JSON to this file")
+	run.Flags().String("coverage", "", "Report Rego coverage in this format (text or json)")
 	run.Flags().Bool("dry-run", false, "Don't actually create Kubernetes objects")
 	run.Flags().Duration("check-timeout", time.Second*30, "Timeout for evaluating check steps")
 	run.Flags().StringArray("param", []string{}, "Additional Rego parameter(s) in key=value format")
@@ -82,6 +186,10 @@ Anything Protocol) results.
 	run.Flags().StringSlice("fixtures", []string{}, "Additional Kubernetes resource fixtures")
 	run.Flags().StringSlice("policies", []string{}, "Additional Rego policy packages")
 	run.Flags().String("format", "tree", "Test results output format")
+	run.Flags().BoolP("verbose", "v", false, "Show the informational results of passing steps")
+	run.Flags().StringSlice("tags", []string{}, "Run only documents matching these tag expressions")
+	run.Flags().StringSlice("skip-tags", []string{}, "Skip documents matching these tag expressions")
+	run.Flags().Bool("list", false, "List the selected documents without running them")
 
 	return CommandWithDefaults(run)
 }
@@ -89,8 +197,27 @@ Anything Protocol) results.
 func runCmd(cmd *cobra.Command, args []string) error {
 	traceFlags := strings.Split(must.String(cmd.Flags().GetString("trace")), ",")
 
-	if err := loadFixtures(
-		must.StringSlice(cmd.Flags().GetStringSlice("fixtures"))); err != nil {
+	paths, err := findDocuments(args,
+		must.StringSlice(cmd.Flags().GetStringSlice("fixtures")))
+	if err != nil {
+		return ExitError{Code: EX_NOINPUT, Err: err}
+	}
+
+	selector := doc.TagSelector{
+		Include: must.StringSlice(cmd.Flags().GetStringSlice("tags")),
+		Exclude: must.StringSlice(cmd.Flags().GetStringSlice("skip-tags")),
+	}
+
+	paths = selectDocuments(paths, &selector)
+
+	if must.Bool(cmd.Flags().GetBool("list")) {
+		listDocuments(paths)
+		return nil
+	}
+
+	fixtures, err := loadFixtures(
+		must.StringSlice(cmd.Flags().GetStringSlice("fixtures")))
+	if err != nil {
 		return ExitError{Code: EX_NOINPUT, Err: err}
 	}
 
@@ -105,30 +232,55 @@ func runCmd(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to initialize Kubernetes context: %s", err)
 	}
 
-	var recorder test.Recorder
-
-	switch must.String(cmd.Flags().GetString("format")) {
-	case "tree":
-		recorder = test.StackRecorders(&test.TreeWriter{}, test.DefaultRecorder)
-	case "tap":
-		recorder = test.StackRecorders(&test.TapWriter{}, test.DefaultRecorder)
+	coverageFormat := must.String(cmd.Flags().GetString("coverage"))
+	switch coverageFormat {
+	case "", "text", "json":
 	default:
-		return ExitErrorf(EX_USAGE, "invalid test output format %q",
-			must.String(cmd.Flags().GetString("format")))
+		return ExitErrorf(EX_USAGE, "invalid coverage format %q", coverageFormat)
+	}
+
+	writer, err := newResultWriter(
+		must.String(cmd.Flags().GetString("format")),
+		must.Bool(cmd.Flags().GetBool("verbose")))
+	if err != nil {
+		return err
+	}
+
+	recorder := test.StackRecorders(writer, test.DefaultRecorder)
+
+	if path := must.String(cmd.Flags().GetString("save")); path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("failed to create results file: %w", err)
+		}
+
+		defer f.Close()
+
+		recorder = test.StackRecorders(test.NewJSONWriter(f), recorder)
 	}
 
 	opts := []test.RunOpt{
 		test.KubeClientOpt(kube),
-		test.RecorderOpt(recorder),
+		test.FixtureSetOpt(fixtures),
 		test.CheckTimeoutOpt(must.Duration(cmd.Flags().GetDuration("check-timeout"))),
 	}
 
 	opts = append(opts, paramOpts...)
 
+	var coverage *test.Coverage
+	if coverageFormat != "" {
+		coverage = test.NewCoverage()
+		opts = append(opts, test.CoverageOpt(coverage))
+	}
+
 	if must.Bool(cmd.Flags().GetBool("preserve")) {
 		opts = append(opts, test.PreserveObjectsOpt())
 	}
 
+	if dir := must.String(cmd.Flags().GetString("artifacts")); dir != "" {
+		opts = append(opts, test.ArtifactsDirOpt(dir))
+	}
+
 	if must.Bool(cmd.Flags().GetBool("dry-run")) {
 		opts = append(opts, test.DryRunOpt())
 	}
@@ -167,17 +319,39 @@ func runCmd(cmd *cobra.Command, args []string) error {
 	// TODO(jpeach): set user agent from program version.
 	kube.SetUserAgent("modden/TODO")
 
-	for _, path := range args {
-		docCloser := recorder.NewDocument(path)
-		testDoc := validateDocument(path, recorder)
+	for _, path := range paths {
+		d := recorder.NewDocument(path)
+		testDoc := validateDocument(path, d)
 
-		if recorder.ShouldContinue() {
-			if err := test.Run(testDoc, opts...); err != nil {
+		if d.ShouldContinue() {
+			if err := test.Run(testDoc, append(opts, test.DocumentOpt(d))...); err != nil {
 				return fmt.Errorf("failed to run tests: %s", err)
 			}
 		}
 
-		docCloser.Close()
+		d.Close()
+	}
+
+	writeTimingSummary(must.String(cmd.Flags().GetString("format")), test.Documents())
+
+	if coverage != nil {
+		format := must.String(cmd.Flags().GetString("format"))
+		if err := writeCoverage(coverageOutput(format), format,
+			coverageFormat, coverage.Report()); err != nil {
+			return err
+		}
+	}
+
+	if path := must.String(cmd.Flags().GetString("html")); path != "" {
+		if err := writeReport(path, "HTML", test.WriteHTMLReport, test.Documents()); err != nil {
+			return err
+		}
+	}
+
+	if path := must.String(cmd.Flags().GetString("junit")); path != "" {
+		if err := writeReport(path, "JUnit", test.WriteJUnitReport, test.Documents()); err != nil {
+			return err
+		}
 	}
 
 	if recorder.Failed() {
@@ -187,6 +361,104 @@ func runCmd(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// newResultWriter returns a Recorder that writes test results to
+// standard output in the given format.
+func newResultWriter(format string, verbose bool) (test.Recorder, error) {
+	switch format {
+	case "tree":
+		return test.NewTreeWriter(verbose), nil
+	case "tap":
+		return &test.TapWriter{}, nil
+	case "json":
+		return test.NewJSONWriter(os.Stdout), nil
+	default:
+		return nil, ExitErrorf(EX_USAGE, "invalid test output format %q", format)
+	}
+}
+
+// slowestSteps is the number of steps to show in the timing summary.
+const slowestSteps = 5
+
+// writeTimingSummary prints a summary of the slowest steps and checks
+// to standard output, in a form that suits the output format.
+func writeTimingSummary(format string, docs []*test.Document) {
+	lines := test.TimingSummary(docs, slowestSteps)
+	if len(lines) == 0 {
+		return
+	}
+
+	prefix := ""
+
+	switch format {
+	case "tree":
+		fmt.Println()
+	case "tap":
+		// TAP treats lines that start with "#" as comments.
+		prefix = "# "
+	default:
+		return
+	}
+
+	for _, line := range lines {
+		fmt.Printf("%s%s\n", prefix, line)
+	}
+}
+
+// coverageOutput returns where the Rego coverage report is written
+// for the given output format. The JSON format writes a stream of
+// test documents to standard output, so the coverage report goes to
+// standard error to keep the stream valid.
+func coverageOutput(format string) io.Writer {
+	if format == "json" {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+// writeCoverage prints the Rego coverage report to out in the given
+// coverage format.
+func writeCoverage(out io.Writer, format string, coverageFormat string, report test.CoverageReport) error {
+	switch coverageFormat {
+	case "json":
+		return json.NewEncoder(out).Encode(report)
+	default:
+		prefix := ""
+
+		switch format {
+		case "tree":
+			fmt.Fprintln(out)
+		case "tap":
+			// TAP treats lines that start with "#" as comments.
+			prefix = "# "
+		}
+
+		test.WriteCoverageText(out, prefix, report)
+		return nil
+	}
+}
+
+// writeReport writes the test documents to a new file at the given
+// path, using the given report writer.
+func writeReport(
+	path string,
+	kind string,
+	write func(io.Writer, []*test.Document) error,
+	docs []*test.Document,
+) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s report: %w", kind, err)
+	}
+
+	if err := write(f, docs); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s report: %w", kind, err)
+	}
+
+	return f.Close()
+}
+
 func loadPolicies(paths []string) (map[string]*ast.Module, error) {
 	modules := map[string]*ast.Module{}
 	loadPath := func(filePath string) error {
@@ -215,22 +487,16 @@ func loadPolicies(paths []string) (map[string]*ast.Module, error) {
 	return modules, nil
 }
 
-func loadFixtures(paths []string) error {
-	loadPath := func(filePath string) error {
-		if err := fixture.AddFromFile(filePath); err != nil {
-			return fmt.Errorf("failed to parse %q`: %w", filePath, err)
-		}
-
-		return nil
-	}
+func loadFixtures(paths []string) (fixture.FixtureSet, error) {
+	fixtures := fixture.NewSet()
 
 	for _, p := range paths {
-		if err := utils.WalkFiles(p, loadPath); err != nil {
-			return err
+		if err := fixture.AddFromPath(fixtures, p); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil
+	return fixtures, nil
 }
 
 func validateParams(params []string) ([]test.RunOpt, error) {
@@ -248,19 +514,19 @@ func validateParams(params []string) ([]test.RunOpt, error) {
 	return opts, nil
 }
 
-func validateDocument(path string, r test.Recorder) *doc.Document {
-	stepCloser := r.NewStep(fmt.Sprintf("validating document %q", path))
-	defer stepCloser.Close()
+func validateDocument(path string, d test.DocumentHandle) *doc.Document {
+	s := d.NewStep(fmt.Sprintf("validating document %q", path))
+	defer s.Close()
 
-	r.Update(result.Infof("reading document from %s", path))
+	s.Update(result.Infof("reading document from %s", path))
 
 	testDoc, err := doc.ReadFile(path)
 	if err != nil {
-		r.Update(result.Fatalf("%s", err.Error()))
+		s.Update(result.Fatalf("%s", err.Error()))
 		return nil
 	}
 
-	r.Update(result.Infof(
+	s.Update(result.Infof(
 		"decoding document with %d parts from %s", len(testDoc.Parts), path))
 
 	// Before executing anything, verify that we can decode all the
@@ -270,15 +536,152 @@ func validateDocument(path string, r test.Recorder) *doc.Document {
 		fragType, err := part.Decode()
 		switch err {
 		case nil:
-			r.Update(result.Infof("decoded part %d as %s (lines %s)", i, fragType, part.Location))
+			s.Update(result.Infof("decoded part %d as %s (lines %s)", i, fragType, part.Location))
+			if fragType == doc.FragmentTypeMetadata && i > 0 {
+				s.Update(result.Fatalf("document metadata must be the first fragment (lines %s)",
+					part.Location))
+			}
 		default:
 			if regoErr := utils.AsRegoCompilationErr(err); regoErr != nil {
-				r.Update(result.Fatalf("%s", regoErr.Error()))
+				s.Update(result.Fatalf("%s (lines %s): %s", err, part.Location, regoErr))
 			} else {
-				r.Update(result.Fatalf("%s", err.Error()))
+				s.Update(result.Fatalf("%s (lines %s)", err, part.Location))
 			}
 		}
 	}
 
 	return testDoc
 }
+
+// isDocumentPath returns whether the given path looks like a test
+// document. YAML and JSON files are test documents. Since Markdown
+// files are usually just documentation, they are only test documents
+// if they opt in by starting with document metadata.
+func isDocumentPath(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	case ".md", ".markdown":
+		md, err := readMetadata(filePath)
+		return err == nil && md != nil
+	default:
+		return false
+	}
+}
+
+// isSkippedPath returns whether a file that was found by walking the
+// root directory is not a test document, because it is under one of
+// the fixture paths, or it is in a kustomization directory.
+func isSkippedPath(root string, filePath string, fixtures []string) bool {
+	for _, f := range fixtures {
+		if utils.IsSubPath(f, filePath) {
+			return true
+		}
+	}
+
+	// Kustomization files and resources can be in any directory
+	// between the file and the root.
+	for dir := filepath.Dir(filePath); utils.IsSubPath(root, dir); dir = filepath.Dir(dir) {
+		if kustomize.IsKustomizationDir(dir) {
+			return true
+		}
+
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+
+	return false
+}
+
+// findDocuments expands the given paths into a list of test documents.
+// Paths to files are always included, but directories are walked for
+// files that look like test documents. Files under the given fixture
+// paths and in kustomization directories are skipped when walking.
+func findDocuments(args []string, fixtures []string) ([]string, error) {
+	var paths []string
+
+	for _, arg := range args {
+		isDir := utils.IsDirPath(arg)
+
+		if err := utils.WalkFiles(arg, func(filePath string) error {
+			if !isDir {
+				paths = append(paths, filePath)
+				return nil
+			}
+
+			if !isSkippedPath(arg, filePath, fixtures) && isDocumentPath(filePath) {
+				paths = append(paths, filePath)
+			}
+
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// readMetadata returns the metadata for the test document at the
+// given path. If the document has no metadata, readMetadata returns nil.
+func readMetadata(filePath string) (*doc.Metadata, error) {
+	testDoc, err := doc.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(testDoc.Parts) == 0 {
+		return nil, nil
+	}
+
+	if _, err := testDoc.Parts[0].Decode(); err != nil {
+		return nil, err
+	}
+
+	return testDoc.Metadata(), nil
+}
+
+// selectDocuments returns the test documents whose tags match the
+// selector. Documents that can't be read are always selected so that
+// the errors are reported when they are run.
+func selectDocuments(paths []string, selector *doc.TagSelector) []string {
+	var selected []string
+
+	for _, p := range paths {
+		md, err := readMetadata(p)
+		switch {
+		case err != nil:
+			selected = append(selected, p)
+		case md == nil:
+			if selector.Matches(nil) {
+				selected = append(selected, p)
+			}
+		default:
+			if selector.Matches(md.Tags) {
+				selected = append(selected, p)
+			}
+		}
+	}
+
+	return selected
+}
+
+// listDocuments prints a table of the given test documents.
+func listDocuments(paths []string) {
+	table := uitable.New()
+	table.AddRow("PATH", "NAME", "TAGS")
+
+	for _, p := range paths {
+		name, tags := "", ""
+
+		if md, err := readMetadata(p); err == nil && md != nil {
+			name = md.Name
+			tags = strings.Join(md.Tags, ",")
+		}
+
+		table.AddRow(p, name, tags)
+	}
+
+	fmt.Println(table)
+}
@@ -1,10 +1,15 @@
 package cmd
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/jpeach/modden/pkg/doc"
 	"github.com/jpeach/modden/pkg/test"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParamValidation(t *testing.T) {
@@ -24,3 +29,70 @@ func TestParamValidation(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(opts))
 }
+
+func TestSelectDocuments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "select")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, tags string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(`apiVersion: modden/v1alpha1
+kind: Document
+metadata:
+  tags: `+tags+`
+`), 0644))
+		return p
+	}
+
+	smoke := write("smoke.yaml", "[smoke]")
+	slow := write("nested/slow.yml", "[smoke, slow]")
+	write("notes.txt", "[]")
+
+	// Markdown documents opt in with a metadata block.
+	markdown := filepath.Join(dir, "nested/doc.md")
+	require.NoError(t, ioutil.WriteFile(markdown, []byte(`# Test
+
+`+"```yaml"+`
+apiVersion: modden/v1alpha1
+kind: Document
+`+"```"+`
+`), 0644))
+
+	// Markdown without metadata is just documentation.
+	readme := filepath.Join(dir, "README.md")
+	require.NoError(t, ioutil.WriteFile(readme, []byte("# Tests\n\n```yaml\nkind: Example\n```\n"), 0644))
+
+	// Fixtures and kustomizations aren't test documents.
+	fixture := write("fixtures/httpbin.yaml", "[]")
+	write("kustomize/kustomization.yaml", "[]")
+	write("kustomize/base/deployment.yaml", "[]")
+
+	paths, err := findDocuments([]string{dir}, []string{filepath.Join(dir, "fixtures")})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{smoke, slow, markdown}, paths)
+
+	// Explicit paths are always included.
+	paths, err = findDocuments([]string{filepath.Join(dir, "notes.txt"), readme, fixture},
+		[]string{filepath.Join(dir, "fixtures")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "notes.txt"), readme, fixture}, paths)
+
+	assert.ElementsMatch(t, []string{smoke},
+		selectDocuments([]string{smoke, slow}, &doc.TagSelector{Exclude: []string{"slow"}}))
+	assert.ElementsMatch(t, []string{smoke, slow},
+		selectDocuments([]string{smoke, slow}, &doc.TagSelector{Include: []string{"smoke"}}))
+
+	// Unreadable documents are selected so they can report errors.
+	assert.ElementsMatch(t, []string{"missing.yaml"},
+		selectDocuments([]string{"missing.yaml"}, &doc.TagSelector{Include: []string{"smoke"}}))
+}
+
+func TestCoverageOutput(t *testing.T) {
+	assert.Equal(t, os.Stdout, coverageOutput("tree"))
+	assert.Equal(t, os.Stdout, coverageOutput("tap"))
+
+	// Coverage must not be mixed into the JSON results stream.
+	assert.Equal(t, os.Stderr, coverageOutput("json"))
+}
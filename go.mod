@@ -3,21 +3,22 @@ module github.com/jpeach/modden
 go 1.14
 
 require (
-	github.com/fatih/color v1.9.0 // indirect
-	github.com/ghodss/yaml v1.0.0 // indirect
+	github.com/evanphx/json-patch v4.5.0+incompatible
+	github.com/fatih/color v1.9.0
 	github.com/go-bindata/go-bindata v3.1.2+incompatible
 	github.com/google/go-cmp v0.3.1
 	github.com/google/uuid v1.1.1
 	github.com/gosuri/uitable v0.0.4
 	github.com/magiconair/properties v1.8.0
 	github.com/open-policy-agent/opa v0.19.0-rc1
-	github.com/pkg/errors v0.8.1 // indirect
 	github.com/spf13/cobra v0.0.5
 	github.com/stretchr/testify v1.4.0
+	golang.org/x/crypto v0.0.0-20190923035154-9ee001bba392
 	k8s.io/api v0.18.0-alpha.2
 	k8s.io/apimachinery v0.18.0-alpha.2
 	k8s.io/client-go v0.18.0-alpha.2
 	k8s.io/utils v0.0.0-20191217005138-9e5e9d854fcc
+	sigs.k8s.io/kustomize/api v0.3.2
 	sigs.k8s.io/kustomize/kyaml v0.0.11
 	sigs.k8s.io/yaml v1.1.0
 )
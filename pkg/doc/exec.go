@@ -0,0 +1,92 @@
+package doc
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// ExecKey is the special key that marks a Fragment as a local
+// command to execute.
+const ExecKey = "$exec"
+
+// Exec describes a local command to execute. It is decoded from a
+// document fragment that looks like this:
+//
+//	$exec:
+//	  command: openssl
+//	  args: [genrsa, -out, tls.key, "2048"]
+//	  env:
+//	    OPENSSL_CONF: /dev/null
+//	  dir: certs
+//	  timeout: 30s
+//
+// A command that sets "background: true" is started in the background
+// and runs until the end of the test document.
+type Exec struct {
+	// Command is the name or path of the command to run.
+	Command string
+	// Args are the arguments to pass to the command.
+	Args []string
+	// Env holds additional environment variables for the command.
+	Env map[string]string
+	// Dir is the working directory for the command.
+	Dir string
+	// Timeout is the maximum time that the command may run for.
+	Timeout time.Duration
+	// Background is set if the command should run in the
+	// background until the end of the test document.
+	Background bool
+}
+
+func isExec(u *unstructured.Unstructured) bool {
+	_, ok := u.Object[ExecKey]
+	return ok
+}
+
+func decodeExec(data []byte) (*Exec, error) {
+	var fragment struct {
+		Exec struct {
+			Command    string            `json:"command"`
+			Args       []string          `json:"args"`
+			Env        map[string]string `json:"env"`
+			Dir        string            `json:"dir"`
+			Timeout    string            `json:"timeout"`
+			Background bool              `json:"background"`
+		} `json:"$exec"`
+	}
+
+	if err := yaml.UnmarshalStrict(data, &fragment); err != nil {
+		return nil, err
+	}
+
+	if fragment.Exec.Command == "" {
+		return nil, errors.New("missing exec command")
+	}
+
+	e := Exec{
+		Command:    fragment.Exec.Command,
+		Args:       fragment.Exec.Args,
+		Env:        fragment.Exec.Env,
+		Dir:        fragment.Exec.Dir,
+		Background: fragment.Exec.Background,
+	}
+
+	if t := fragment.Exec.Timeout; t != "" {
+		if e.Background {
+			return nil, errors.New("background commands can't have a timeout")
+		}
+
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec timeout %q: %w", t, err)
+		}
+
+		e.Timeout = d
+	}
+
+	return &e, nil
+}
@@ -24,6 +24,13 @@ const (
 	FragmentTypeObject
 	// FragmentTypeModule indicates this Fragment contains a Rego module.
 	FragmentTypeModule
+	// FragmentTypeMetadata indicates this Fragment contains document Metadata.
+	FragmentTypeMetadata
+	// FragmentTypeExec indicates this Fragment contains a local command.
+	FragmentTypeExec
+	// FragmentTypeKustomize indicates this Fragment contains a
+	// kustomization directory reference.
+	FragmentTypeKustomize
 )
 
 var _ error = &InvalidFragmentErr{}
@@ -34,9 +41,16 @@ type InvalidFragmentErr struct {
 	// Type is the fragment type that was expected at the point
 	// the error happened.
 	Type FragmentType
+
+	// Reason optionally describes why the fragment is invalid.
+	Reason string
 }
 
 func (e *InvalidFragmentErr) Error() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+
 	return fmt.Sprintf("invalid %s fragment", e.Type)
 }
 
@@ -49,6 +63,12 @@ func (t FragmentType) String() string {
 		return "Kubernetes"
 	case FragmentTypeModule:
 		return "Rego"
+	case FragmentTypeMetadata:
+		return "metadata"
+	case FragmentTypeExec:
+		return "exec"
+	case FragmentTypeKustomize:
+		return "kustomize"
 	case FragmentTypeInvalid:
 		return "invalid"
 	default:
@@ -58,6 +78,15 @@ func (t FragmentType) String() string {
 
 // Location tracks the lines that bound a Fragment within some larger Document.
 type Location struct {
+	// File is the path of the file that the Fragment was read
+	// from. This may not be the same as the Document name if the
+	// Fragment was included from some other Document.
+	File string
+
+	// Included is set if the Fragment was included from some
+	// other Document.
+	Included bool
+
 	// Start is the line number this location starts on.
 	Start int
 
@@ -65,7 +94,14 @@ type Location struct {
 	End int
 }
 
+// String formats the location as a line range. Since the lines of
+// included Fragments are in a different file, their location is
+// prefixed by the file name.
 func (l Location) String() string {
+	if l.Included && l.File != "" {
+		return fmt.Sprintf("%s:%d-%d", l.File, l.Start, l.End)
+	}
+
 	return fmt.Sprintf("%d-%d", l.Start, l.End)
 }
 
@@ -75,8 +111,15 @@ type Fragment struct {
 	Type     FragmentType
 	Location Location
 
-	object *unstructured.Unstructured
-	module *ast.Module
+	// Marker is the explicit fragment type marker, if there is
+	// one (see MarkerRego and MarkerYAML).
+	Marker string
+
+	object    *unstructured.Unstructured
+	module    *ast.Module
+	metadata  *Metadata
+	exec      *Exec
+	kustomize *Kustomize
 }
 
 // Object returns the Kubernetes object if there is one.
@@ -99,11 +142,53 @@ func (f *Fragment) Rego() *ast.Module {
 	}
 }
 
+// Metadata returns the document Metadata if there is one.
+func (f *Fragment) Metadata() *Metadata {
+	switch f.Type {
+	case FragmentTypeMetadata:
+		return f.metadata
+	default:
+		return nil
+	}
+}
+
+// Exec returns the local command if there is one.
+func (f *Fragment) Exec() *Exec {
+	switch f.Type {
+	case FragmentTypeExec:
+		return f.exec
+	default:
+		return nil
+	}
+}
+
+// Kustomize returns the kustomization reference if there is one.
+func (f *Fragment) Kustomize() *Kustomize {
+	switch f.Type {
+	case FragmentTypeKustomize:
+		return f.kustomize
+	default:
+		return nil
+	}
+}
+
 func hasKindVersion(u *unstructured.Unstructured) bool {
 	k := u.GetObjectKind().GroupVersionKind()
 	return len(k.Version) > 0 && len(k.Kind) > 0
 }
 
+// isFixtureGroup returns whether the object is a reference to a
+// group of fixtures. A fixture group reference doesn't name a
+// specific object, so it has no version or kind:
+//
+//	$apply:
+//	  fixture:
+//	    group: httpbin
+func isFixtureGroup(u *unstructured.Unstructured) bool {
+	group, ok, _ := unstructured.NestedString(u.Object, "$apply", "fixture", "group")
+	return ok && group != ""
+}
+
 func decodeYAMLOrJSON(data []byte) (*unstructured.Unstructured, error) {
 	buffer := bytes.NewReader(data)
 	decoder := yaml.NewYAMLOrJSONDecoder(buffer, buffer.Len())
@@ -116,8 +201,11 @@ func decodeYAMLOrJSON(data []byte) (*unstructured.Unstructured, error) {
 	return &unstructured.Unstructured{Object: into}, nil
 }
 
-func decodeModule(data []byte) (*ast.Module, error) {
-	m, err := utils.ParseCheckFragment(string(data))
+// decodeModule parses the fragment data as Rego. The parsed
+// module locations refer to the document that the fragment was
+// read from.
+func decodeModule(loc Location, data []byte) (*ast.Module, error) {
+	m, err := utils.ParseCheckFragmentAt(loc.File, loc.Start, string(data))
 	if err != nil {
 		return nil, err
 	}
@@ -140,21 +228,56 @@ func (f *Fragment) IsDecoded() bool {
 	}
 }
 
-// Decode attempts to parse the Fragment.
+// Decode attempts to parse the Fragment. If the Fragment has a type
+// marker, either from the separator that precedes it or from a marker
+// comment on its first line, it is strictly decoded as the marked
+// type. Otherwise, the Fragment type is guessed from its content.
 func (f *Fragment) Decode() (FragmentType, error) {
-	if u, err := decodeYAMLOrJSON(f.Bytes); err == nil {
-		// It's only a valid object if it has a version & kind.
-		if hasKindVersion(u) {
-			f.Type = FragmentTypeObject
-			f.object = u
-			return f.Type, nil
+	if f.Marker == "" {
+		f.Marker = headerMarker(f.Bytes)
+	}
+
+	switch f.Marker {
+	case "":
+		return f.decodeAny()
+	case MarkerYAML:
+		u, err := decodeYAMLOrJSON(f.Bytes)
+		if err != nil {
+			return FragmentTypeInvalid,
+				utils.ChainErrors(
+					&InvalidFragmentErr{
+						Type:   FragmentTypeObject,
+						Reason: fmt.Sprintf("fragment marked as YAML failed to parse: %s", err),
+					}, err,
+				)
+		}
+
+		return f.decodeObject(u)
+	case MarkerRego:
+		return f.decodeRego(false)
+	default:
+		return FragmentTypeInvalid, &InvalidFragmentErr{
+			Type:   FragmentTypeUnknown,
+			Reason: fmt.Sprintf("unknown fragment marker %q", f.Marker),
 		}
+	}
+}
 
-		return FragmentTypeInvalid,
-			utils.ChainErrors(
-				&InvalidFragmentErr{Type: FragmentTypeObject},
-				fmt.Errorf("YAML fragment is not a Kubernetes object"),
-			)
+// decodeAny guesses the type of an unmarked Fragment. Anything that
+// decodes as YAML is expected to be some kind of object, and anything
+// else is expected to be Rego.
+func (f *Fragment) decodeAny() (FragmentType, error) {
+	suspectRego := looksLikeRego(f.Bytes)
+
+	if u, err := decodeYAMLOrJSON(f.Bytes); err == nil {
+		fragType, err := f.decodeObject(u)
+
+		// Rego can sometimes parse as YAML. If the fragment
+		// isn't any kind of object, but looks like Rego, then
+		// decode it as Rego to get a useful error.
+		if err == nil || !suspectRego {
+			return fragType, err
+		}
 	}
 
 	// At this point, we don't strictly know that this fragment
@@ -162,19 +285,93 @@ func (f *Fragment) Decode() (FragmentType, error) {
 	// then we can't know whether to propagate Rego syntax errors.
 	// Since we do want to propagate errors so that users can debug
 	// scripts, we have to assume this is meant to be Rego.
+	return f.decodeRego(!suspectRego)
+}
+
+// decodeObject decodes a Fragment that holds a YAML object.
+func (f *Fragment) decodeObject(u *unstructured.Unstructured) (FragmentType, error) {
+	if isMetadata(u) {
+		m, err := decodeMetadata(f.Bytes)
+		if err != nil {
+			return FragmentTypeInvalid,
+				utils.ChainErrors(
+					&InvalidFragmentErr{Type: FragmentTypeMetadata}, err,
+				)
+		}
+
+		f.Type = FragmentTypeMetadata
+		f.metadata = m
+		return f.Type, nil
+	}
+
+	if isExec(u) {
+		e, err := decodeExec(f.Bytes)
+		if err != nil {
+			return FragmentTypeInvalid,
+				utils.ChainErrors(
+					&InvalidFragmentErr{Type: FragmentTypeExec}, err,
+				)
+		}
+
+		f.Type = FragmentTypeExec
+		f.exec = e
+		return f.Type, nil
+	}
+
+	if isKustomize(u) {
+		k, err := decodeKustomize(f.Bytes)
+		if err != nil {
+			return FragmentTypeInvalid,
+				utils.ChainErrors(
+					&InvalidFragmentErr{Type: FragmentTypeKustomize}, err,
+				)
+		}
+
+		f.Type = FragmentTypeKustomize
+		f.kustomize = k
+		return f.Type, nil
+	}
+
+	// It's only a valid object if it has a version & kind,
+	// unless it is a reference to a group of fixture objects.
+	if hasKindVersion(u) || isFixtureGroup(u) {
+		f.Type = FragmentTypeObject
+		f.object = u
+		return f.Type, nil
+	}
+
+	return FragmentTypeInvalid,
+		utils.ChainErrors(
+			&InvalidFragmentErr{Type: FragmentTypeObject},
+			fmt.Errorf("YAML fragment is not a Kubernetes object"),
+		)
+}
 
-	m, err := decodeModule(f.Bytes)
+// decodeRego decodes a Fragment that holds Rego. If allowEmpty is
+// true, a Fragment that parses but contains no rules is reported as
+// an unknown fragment rather than an error.
+func (f *Fragment) decodeRego(allowEmpty bool) (FragmentType, error) {
+	m, err := decodeModule(f.Location, f.Bytes)
 	if err != nil {
-		return FragmentTypeInvalid,
-			utils.ChainErrors(
-				&InvalidFragmentErr{Type: FragmentTypeModule}, err,
-			)
+		fragErr := &InvalidFragmentErr{Type: FragmentTypeModule}
+		if f.Marker == "" && !allowEmpty {
+			fragErr.Reason = "fragment looks like Rego but failed to parse"
+		}
+
+		return FragmentTypeInvalid, utils.ChainErrors(fragErr, err)
 	}
 
 	// Rego will parse raw JSON and YAML, but in that
 	// case there won't be a any rules in the module.
 	if len(m.Rules) == 0 {
-		return FragmentTypeUnknown, nil
+		if allowEmpty {
+			return FragmentTypeUnknown, nil
+		}
+
+		return FragmentTypeInvalid, &InvalidFragmentErr{
+			Type:   FragmentTypeModule,
+			Reason: "Rego fragment has no rules",
+		}
 	}
 
 	f.Type = FragmentTypeModule
@@ -185,7 +382,15 @@ func (f *Fragment) Decode() (FragmentType, error) {
 // NewRegoFragment decodes the given data and returns a new Fragment
 // of type FragmentTypeModule.
 func NewRegoFragment(data []byte) (*Fragment, error) {
-	frag := Fragment{Bytes: data}
+	return NewRegoFragmentAt(Location{}, data)
+}
+
+// NewRegoFragmentAt decodes the given data, which was read from the
+// given location, and returns a new Fragment of type
+// FragmentTypeModule. The locations in the parsed module, and in any
+// errors, refer to the given location.
+func NewRegoFragmentAt(loc Location, data []byte) (*Fragment, error) {
+	frag := Fragment{Bytes: data, Location: loc}
 
 	fragType, err := frag.Decode()
 	if err != nil {
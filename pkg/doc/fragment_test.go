@@ -1,15 +1,20 @@
 package doc
 
 import (
+	"strings"
 	"testing"
 
+	"github.com/jpeach/modden/pkg/utils"
+
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestParseFragment(t *testing.T) {
 	type testcase struct {
-		Data string
-		Want FragmentType
+		Data   string
+		Marker string
+		Want   FragmentType
 	}
 
 	run := func(t *testing.T, name string, tc testcase) {
@@ -18,7 +23,8 @@ func TestParseFragment(t *testing.T) {
 			t.Helper()
 
 			f := Fragment{
-				Bytes: []byte(tc.Data),
+				Bytes:  []byte(tc.Data),
+				Marker: tc.Marker,
 			}
 
 			fragType, err := f.Decode()
@@ -55,6 +61,24 @@ func TestParseFragment(t *testing.T) {
 				if f.Rego() == nil {
 					t.Errorf("nil module for rego fragment")
 				}
+			case FragmentTypeMetadata:
+				if f.Metadata() == nil {
+					t.Errorf("nil metadata for metadata fragment")
+				}
+				if f.Object() != nil {
+					t.Errorf("non-nil object for metadata fragment")
+				}
+			case FragmentTypeExec:
+				if f.Exec() == nil {
+					t.Errorf("nil exec for exec fragment")
+				}
+				if f.Object() != nil {
+					t.Errorf("non-nil object for exec fragment")
+				}
+			case FragmentTypeKustomize:
+				if f.Kustomize() == nil {
+					t.Errorf("nil kustomize for kustomize fragment")
+				}
 			default:
 				t.Errorf("invalid fragment type %d", fragType)
 			}
@@ -88,6 +112,25 @@ metadata:
 		Want: FragmentTypeObject,
 	})
 
+	run(t, "fixture group", testcase{
+		Data: `
+$apply:
+  fixture:
+    group: httpbin
+    as: test/httpbin
+`,
+		Want: FragmentTypeObject,
+	})
+
+	run(t, "fixture without group", testcase{
+		Data: `
+$apply:
+  fixture:
+    as: test/httpbin
+`,
+		Want: FragmentTypeInvalid,
+	})
+
 	run(t, "JSON K8s object", testcase{
 		Data: `
 {
@@ -104,6 +147,90 @@ metadata:
 		Want: FragmentTypeObject,
 	})
 
+	run(t, "document metadata", testcase{
+		Data: `
+apiVersion: modden/v1alpha1
+kind: Document
+metadata:
+  name: test
+  tags: [smoke]
+spec:
+  checkTimeout: 1m
+`,
+		Want: FragmentTypeMetadata,
+	})
+
+	run(t, "invalid document metadata", testcase{
+		Data: `
+apiVersion: modden/v1alpha1
+kind: Document
+spec:
+  checkTimeout: never
+`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "exec command", testcase{
+		Data: `
+$exec:
+  command: openssl
+  args: [genrsa, -out, tls.key, "2048"]
+  env:
+    OPENSSL_CONF: /dev/null
+  timeout: 30s
+`,
+		Want: FragmentTypeExec,
+	})
+
+	run(t, "exec without command", testcase{
+		Data: `
+$exec:
+  args: [foo]
+`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "exec with unknown field", testcase{
+		Data: `
+$exec:
+  command: echo
+  arguments: [foo]
+`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "background exec command", testcase{
+		Data: `
+$exec:
+  command: kubectl
+  args: [port-forward, svc/echo, "8080:80"]
+  background: true
+`,
+		Want: FragmentTypeExec,
+	})
+
+	run(t, "background exec with timeout", testcase{
+		Data: `
+$exec:
+  command: kubectl
+  background: true
+  timeout: 30s
+`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "kustomization", testcase{
+		Data: `$kustomize: overlays/test`,
+		Want: FragmentTypeKustomize,
+	})
+
+	run(t, "kustomization with extra fields", testcase{
+		Data: `
+$kustomize: overlays/test
+namespace: test`,
+		Want: FragmentTypeInvalid,
+	})
+
 	run(t, "Rego composite value", testcase{
 		Data: `
 		rect := {"width": 2, "height": 4}`,
@@ -114,4 +241,111 @@ metadata:
 		Data: `t { x := 42; y := 41; x > y }`,
 		Want: FragmentTypeModule,
 	})
+
+	run(t, "Rego parsed as YAML", testcase{
+		Data: `
+error[msg]: {
+  msg := "colon after the rule head"
+}`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "Rego with no rules", testcase{
+		Data: `import data.foo`,
+		Want: FragmentTypeInvalid,
+	})
+
+	run(t, "header marked Rego", testcase{
+		Data: `# modden: rego
+t { true }`,
+		Want: FragmentTypeModule,
+	})
+
+	run(t, "marked Rego with no rules", testcase{
+		Data:   `# just a comment`,
+		Marker: MarkerRego,
+		Want:   FragmentTypeInvalid,
+	})
+
+	run(t, "marked YAML", testcase{
+		Data: `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: marked`,
+		Marker: MarkerYAML,
+		Want:   FragmentTypeObject,
+	})
+
+	run(t, "marked YAML is not Rego", testcase{
+		Data:   `t { x := 42; y := 41; x > y }`,
+		Marker: MarkerYAML,
+		Want:   FragmentTypeInvalid,
+	})
+
+	run(t, "unknown marker", testcase{
+		Data: `# modden: toml
+foo = "bar"`,
+		Want: FragmentTypeInvalid,
+	})
+}
+
+func TestFragmentErrors(t *testing.T) {
+	f := Fragment{Bytes: []byte(`
+error[msg] {
+  msg := "missing brace"
+`)}
+
+	_, err := f.Decode()
+	assert.EqualError(t, err, "fragment looks like Rego but failed to parse")
+	assert.NotNil(t, utils.AsRegoCompilationErr(err))
+
+	f = Fragment{Bytes: []byte(`foo: [`), Marker: MarkerYAML}
+	_, err = f.Decode()
+	assert.Contains(t, err.Error(), "fragment marked as YAML failed to parse")
+}
+
+func TestFragmentRegoLocations(t *testing.T) {
+	f := Fragment{
+		Bytes:    []byte("error[msg] {\n  msg := \"x\"\n}"),
+		Location: Location{File: "examples/status.yaml", Start: 42, End: 44},
+	}
+
+	_, err := f.Decode()
+	assert.NoError(t, err)
+
+	loc := f.Rego().Rules[0].Body[0].Location
+	assert.Equal(t, "examples/status.yaml:43", loc.String())
+
+	f = Fragment{
+		Bytes:    []byte("error[msg] {\n  msg := \"x\"\n"),
+		Location: Location{File: "examples/status.yaml", Start: 42, End: 43},
+	}
+
+	_, err = f.Decode()
+	errs := utils.AsRegoCompilationErr(err)
+	if assert.NotEmpty(t, errs) {
+		assert.Equal(t, "examples/status.yaml", errs[0].Location.File)
+	}
+}
+
+func TestFragmentRegoLocationsAfterBlankLine(t *testing.T) {
+	d, err := ReadDocument(strings.NewReader(`apiVersion: v1
+kind: Namespace
+---
+
+error[msg] {
+  msg := "x"
+}
+`))
+	require.NoError(t, err)
+	require.Len(t, d.Parts, 2)
+
+	f := d.Parts[1]
+	_, err = f.Decode()
+	require.NoError(t, err)
+
+	// The rule is on line 5, after the separator and a blank line.
+	assert.Equal(t, 5, f.Rego().Rules[0].Location.Row)
+	assert.Equal(t, 6, f.Rego().Rules[0].Body[0].Location.Row)
 }
@@ -0,0 +1,43 @@
+package doc
+
+import (
+	"errors"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// KustomizeKey is the special key that marks a Fragment as a
+// kustomization to build and apply.
+const KustomizeKey = "$kustomize"
+
+// Kustomize describes a kustomization directory whose objects should
+// be applied. It is decoded from a document fragment that looks like
+// this:
+//
+//	$kustomize: overlays/test
+type Kustomize struct {
+	// Dir is the path to the kustomization directory.
+	Dir string
+}
+
+func isKustomize(u *unstructured.Unstructured) bool {
+	_, ok := u.Object[KustomizeKey]
+	return ok
+}
+
+func decodeKustomize(data []byte) (*Kustomize, error) {
+	var fragment struct {
+		Dir string `json:"$kustomize"`
+	}
+
+	if err := yaml.UnmarshalStrict(data, &fragment); err != nil {
+		return nil, err
+	}
+
+	if fragment.Dir == "" {
+		return nil, errors.New("missing kustomization directory")
+	}
+
+	return &Kustomize{Dir: fragment.Dir}, nil
+}
@@ -0,0 +1,173 @@
+package doc
+
+import (
+	"bufio"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jpeach/modden/pkg/utils"
+)
+
+// MarkdownSkipAttribute is the fenced code block attribute that
+// excludes the block from the Document.
+const MarkdownSkipAttribute = "modden:skip"
+
+// markdownFence matches the opening of a fenced code block, capturing
+// the indent, the fence and the info string.
+var markdownFence = regexp.MustCompile("^( {0,3})(```+|~~~+)(.*)$")
+
+// markdownBlock is a fenced code block in a Markdown document.
+type markdownBlock struct {
+	// Info is the list of words in the block info string. The
+	// first word is conventionally the language of the block.
+	Info []string
+	// Start is the line number of the opening fence.
+	Start int
+	// Lines are the content lines of the block.
+	Lines []string
+}
+
+func (b *markdownBlock) language() string {
+	if len(b.Info) == 0 {
+		return ""
+	}
+
+	return strings.ToLower(b.Info[0])
+}
+
+func (b *markdownBlock) skipped() bool {
+	return utils.ContainsString(b.Info, MarkdownSkipAttribute)
+}
+
+// readMarkdownBlocks scans a Markdown document for fenced code blocks.
+func readMarkdownBlocks(in io.Reader) ([]markdownBlock, error) {
+	var blocks []markdownBlock
+	var current *markdownBlock
+
+	indent := 0
+	fence := ""
+	currentLine := 0
+
+	scanner := bufio.NewScanner(in)
+
+	for scanner.Scan() {
+		currentLine++
+		line := scanner.Text()
+
+		if current == nil {
+			m := markdownFence.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+
+			// Backtick fences can't have backticks in the info string.
+			if strings.HasPrefix(m[2], "`") && strings.Contains(m[3], "`") {
+				continue
+			}
+
+			indent = len(m[1])
+			fence = m[2]
+			current = &markdownBlock{
+				Info:  strings.Fields(m[3]),
+				Start: currentLine,
+			}
+
+			continue
+		}
+
+		// The closing fence must use the same character and
+		// be at least as long as the opening fence.
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, fence) &&
+			strings.Trim(trimmed, fence[:1]) == "" &&
+			len(line)-len(strings.TrimLeft(line, " ")) < 4 {
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+
+		// Remove up to the indentation of the opening fence.
+		for i := 0; i < indent && strings.HasPrefix(line, " "); i++ {
+			line = line[1:]
+		}
+
+		current.Lines = append(current.Lines, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// An unclosed block runs to the end of the document.
+	if current != nil {
+		blocks = append(blocks, *current)
+	}
+
+	return blocks, nil
+}
+
+// ReadMarkdown reads a Document from the fenced code blocks of a
+// Markdown document. YAML and JSON blocks are split into Fragments
+// at YAML document separators, in the same way as ReadDocument.
+// Each Rego block becomes a single Fragment. Fragments are marked with
+// the type of the block they came from. All other blocks, and
+// blocks that have the "modden:skip" attribute are ignored. The
+// Fragment locations are the line numbers in the Markdown document.
+func ReadMarkdown(in io.Reader) (*Document, error) {
+	blocks, err := readMarkdownBlocks(in)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := Document{}
+
+	for _, b := range blocks {
+		if b.skipped() || len(b.Lines) == 0 {
+			continue
+		}
+
+		// The first content line follows the opening fence.
+		offset := b.Start
+
+		switch b.language() {
+		case "yaml", "yml", "json":
+			blockDoc, err := ReadDocument(strings.NewReader(utils.JoinLines(b.Lines...)))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, p := range blockDoc.Parts {
+				if p.Marker == "" {
+					p.Marker = MarkerYAML
+				}
+
+				p.Location.Start += offset
+				p.Location.End += offset
+				doc.Parts = append(doc.Parts, p)
+			}
+
+		case "rego":
+			doc.Parts = append(doc.Parts, Fragment{
+				Bytes:  []byte(utils.JoinLines(b.Lines...)),
+				Marker: MarkerRego,
+				Location: Location{
+					Start: offset + 1,
+					End:   offset + len(b.Lines),
+				},
+			})
+		}
+	}
+
+	return &doc, nil
+}
+
+// isMarkdown returns whether the file path names a Markdown document.
+func isMarkdown(filePath string) bool {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".md", ".markdown":
+		return true
+	default:
+		return false
+	}
+}
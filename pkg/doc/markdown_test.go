@@ -0,0 +1,74 @@
+package doc
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadMarkdown(t *testing.T) {
+	input := "# Test document\n" + // 1
+		"\n" + // 2
+		"Create a namespace:\n" + // 3
+		"\n" + // 4
+		"```yaml\n" + // 5
+		"apiVersion: v1\n" + // 6
+		"kind: Namespace\n" + // 7
+		"---\n" + // 8
+		"apiVersion: v1\n" + // 9
+		"```\n" + // 10
+		"\n" + // 11
+		"Check it:\n" + // 12
+		"\n" + // 13
+		"~~~~ Rego\n" + // 14
+		"error[msg] {\n" + // 15
+		"  msg := \"```\"\n" + // 16
+		"}\n" + // 17
+		"~~~~\n" + // 18
+		"\n" + // 19
+		"```yaml modden:skip\n" + // 20
+		"skipped: true\n" + // 21
+		"```\n" + // 22
+		"\n" + // 23
+		"```sh\n" + // 24
+		"kubectl get ns\n" + // 25
+		"```\n" + // 26
+		"\n" + // 27
+		"  ```json\n" + // 28
+		"  {\"indented\": true}\n" + // 29
+		"  ```\n" // 30
+
+	got, err := ReadMarkdown(strings.NewReader(input))
+	require.NoError(t, err)
+
+	want := &Document{
+		Parts: []Fragment{
+			{Bytes: []byte("apiVersion: v1\nkind: Namespace\n"), Marker: MarkerYAML, Location: Location{Start: 6, End: 7}},
+			{Bytes: []byte("apiVersion: v1"), Marker: MarkerYAML, Location: Location{Start: 9, End: 9}},
+			{Bytes: []byte("error[msg] {\n  msg := \"```\"\n}"), Marker: MarkerRego, Location: Location{Start: 15, End: 17}},
+			{Bytes: []byte(`{"indented": true}`), Marker: MarkerYAML, Location: Location{Start: 29, End: 29}},
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(Fragment{})); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	fragType, err := got.Parts[2].Decode()
+	assert.NoError(t, err)
+	assert.EqualValues(t, FragmentTypeModule, fragType)
+}
+
+func TestReadMarkdownUnclosed(t *testing.T) {
+	got, err := ReadMarkdown(strings.NewReader("prose\n```rego\nx := 1\n"))
+	require.NoError(t, err)
+
+	require.Len(t, got.Parts, 1)
+	assert.Equal(t, "x := 1", string(got.Parts[0].Bytes))
+	assert.Equal(t, Location{Start: 3, End: 3}, got.Parts[0].Location)
+	assert.Equal(t, MarkerRego, got.Parts[0].Marker)
+}
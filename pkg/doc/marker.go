@@ -0,0 +1,110 @@
+package doc
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+const (
+	// MarkerRego marks a Fragment that must be decoded as Rego.
+	MarkerRego = "rego"
+
+	// MarkerYAML marks a Fragment that must be decoded as YAML
+	// (or JSON).
+	MarkerYAML = "yaml"
+)
+
+// markerComment matches a fragment type marker comment, which
+// looks like "# modden: rego".
+var markerComment = regexp.MustCompile(`^#\s*modden:\s*(\S+)\s*$`)
+
+// parseMarker returns the fragment type marker from the given
+// comment, or the empty string if the comment is not a marker.
+func parseMarker(comment string) string {
+	m := markerComment.FindStringSubmatch(strings.TrimSpace(comment))
+	if m == nil {
+		return ""
+	}
+
+	switch marker := strings.ToLower(m[1]); marker {
+	case "yml", "json":
+		return MarkerYAML
+	default:
+		return marker
+	}
+}
+
+// headerMarker returns the fragment type marker from the first
+// non-blank line of the fragment data, if there is one.
+func headerMarker(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		return parseMarker(line)
+	}
+
+	return ""
+}
+
+// TraceComment is the text of a Rego comment that opts a check in
+// to tracing, i.e. "# $trace".
+const TraceComment = "$trace"
+
+// IsTraced returns true if the Rego module has a trace comment.
+func IsTraced(m *ast.Module) bool {
+	if m == nil {
+		return false
+	}
+
+	for _, c := range m.Comments {
+		if strings.TrimSpace(string(c.Text)) == TraceComment {
+			return true
+		}
+	}
+
+	return false
+}
+
+// regoPatterns match lines that are very likely to be Rego and
+// unlikely to be YAML.
+var regoPatterns = []*regexp.Regexp{
+	// Package and import statements.
+	regexp.MustCompile(`^package\s+[\w.]+$`),
+	regexp.MustCompile(`^import\s+(data|input|future|rego)\b`),
+	// Default rule values.
+	regexp.MustCompile(`^default\s+\w+\s*:?=`),
+	// Rule heads, e.g. "error[msg] {" or "allow {".
+	regexp.MustCompile(`^\w+(\[[^\]]*\])?\s*(:?=\s*.+)?\s*\{\s*(#.*)?$`),
+	// Local variable assignment.
+	regexp.MustCompile(`^\w+\s*:=`),
+	// References into the data or input documents.
+	regexp.MustCompile(`^(not\s+)?(data|input)\.\w+`),
+}
+
+// looksLikeRego returns true if any line in the fragment data
+// matches a pattern that is characteristic of Rego.
+func looksLikeRego(data []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		for _, r := range regoPatterns {
+			if r.MatchString(line) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
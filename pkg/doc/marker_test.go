@@ -0,0 +1,62 @@
+package doc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMarker(t *testing.T) {
+	assert.Equal(t, MarkerRego, parseMarker("# modden: rego"))
+	assert.Equal(t, MarkerRego, parseMarker("#modden:Rego "))
+	assert.Equal(t, MarkerYAML, parseMarker("# modden: yaml"))
+	assert.Equal(t, MarkerYAML, parseMarker("# modden: json"))
+	assert.Equal(t, "toml", parseMarker("# modden: toml"))
+	assert.Equal(t, "", parseMarker("# a comment"))
+	assert.Equal(t, "", parseMarker(""))
+
+	assert.Equal(t, MarkerRego, headerMarker([]byte("\n# modden: rego\nt { true }")))
+	assert.Equal(t, "", headerMarker([]byte("t { true }\n# modden: rego")))
+}
+
+func TestLooksLikeRego(t *testing.T) {
+	rego := []string{
+		"package foo",
+		"import data.foo",
+		"default allow = false",
+		"error[msg] {",
+		"allow {",
+		"x := 1",
+		"not data.resources.foo",
+	}
+
+	for _, r := range rego {
+		assert.True(t, looksLikeRego([]byte(r)), r)
+	}
+
+	yaml := []string{
+		"",
+		"# error[msg] {",
+		"apiVersion: v1\nkind: Namespace",
+		"spec: {",
+		"foo: bar",
+		"- item",
+	}
+
+	for _, y := range yaml {
+		assert.False(t, looksLikeRego([]byte(y)), y)
+	}
+}
+
+func TestIsTraced(t *testing.T) {
+	traced, err := NewRegoFragment([]byte("# $trace\nerror[msg] { msg := \"traced\" }"))
+	require.NoError(t, err)
+	assert.True(t, IsTraced(traced.Rego()))
+
+	untraced, err := NewRegoFragment([]byte("# not $trace\nerror[msg] { msg := \"untraced\" }"))
+	require.NoError(t, err)
+	assert.False(t, IsTraced(untraced.Rego()))
+
+	assert.False(t, IsTraced(nil))
+}
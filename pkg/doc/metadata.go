@@ -0,0 +1,152 @@
+package doc
+
+import (
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// MetadataVersion is the API version of a document metadata fragment.
+	MetadataVersion = "modden/v1alpha1"
+
+	// MetadataKind is the kind of a document metadata fragment.
+	MetadataKind = "Document"
+)
+
+// Metadata describes a test Document. It is decoded from a leading
+// document fragment that looks like this:
+//
+//	apiVersion: modden/v1alpha1
+//	kind: Document
+//	metadata:
+//	  name: httpbin-status
+//	  description: Verify that the httpbin proxy becomes valid.
+//	  tags: [smoke, httpproxy]
+//	  owners: [jpeach]
+//	spec:
+//	  params: [proxy-address]
+//	  checkTimeout: 60s
+//	  fixtures: [fixtures/]
+type Metadata struct {
+	// Name is a short name for the document.
+	Name string
+	// Description is a human-readable document description.
+	Description string
+	// Tags are arbitrary strings that can be used to select documents.
+	Tags []string
+	// Owners are the people responsible for the document.
+	Owners []string
+	// Params are the names of parameters that must be given to run the document.
+	Params []string
+	// CheckTimeout is the default check timeout for the document.
+	CheckTimeout time.Duration
+	// Fixtures are paths to fixture files or directories that
+	// are used only by this document. Relative paths are relative
+	// to the document.
+	Fixtures []string
+}
+
+// Properties returns the non-empty Metadata fields as a map
+// of JSON-compatible values.
+func (m *Metadata) Properties() map[string]interface{} {
+	props := map[string]interface{}{}
+
+	stringSlice := func(s []string) []interface{} {
+		val := make([]interface{}, len(s))
+		for i := range s {
+			val[i] = s[i]
+		}
+		return val
+	}
+
+	if m.Name != "" {
+		props["name"] = m.Name
+	}
+
+	if m.Description != "" {
+		props["description"] = m.Description
+	}
+
+	if len(m.Tags) > 0 {
+		props["tags"] = stringSlice(m.Tags)
+	}
+
+	if len(m.Owners) > 0 {
+		props["owners"] = stringSlice(m.Owners)
+	}
+
+	if len(m.Params) > 0 {
+		props["params"] = stringSlice(m.Params)
+	}
+
+	if m.CheckTimeout > 0 {
+		props["checkTimeout"] = m.CheckTimeout.String()
+	}
+
+	if len(m.Fixtures) > 0 {
+		props["fixtures"] = stringSlice(m.Fixtures)
+	}
+
+	return props
+}
+
+func isMetadata(u *unstructured.Unstructured) bool {
+	return u.GetAPIVersion() == MetadataVersion &&
+		u.GetKind() == MetadataKind
+}
+
+func decodeMetadata(data []byte) (*Metadata, error) {
+	var fragment struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Metadata   struct {
+			Name        string   `json:"name"`
+			Description string   `json:"description"`
+			Tags        []string `json:"tags"`
+			Owners      []string `json:"owners"`
+		} `json:"metadata"`
+		Spec struct {
+			Params       []string `json:"params"`
+			CheckTimeout string   `json:"checkTimeout"`
+			Fixtures     []string `json:"fixtures"`
+		} `json:"spec"`
+	}
+
+	if err := yaml.UnmarshalStrict(data, &fragment); err != nil {
+		return nil, err
+	}
+
+	m := Metadata{
+		Name:        fragment.Metadata.Name,
+		Description: fragment.Metadata.Description,
+		Tags:        fragment.Metadata.Tags,
+		Owners:      fragment.Metadata.Owners,
+		Params:      fragment.Spec.Params,
+		Fixtures:    fragment.Spec.Fixtures,
+	}
+
+	if t := fragment.Spec.CheckTimeout; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid check timeout %q: %w", t, err)
+		}
+
+		m.CheckTimeout = d
+	}
+
+	return &m, nil
+}
+
+// Metadata returns the Metadata from the leading fragment of the
+// Document, or nil if there is none. The Document fragments must
+// already have been decoded.
+func (d *Document) Metadata() *Metadata {
+	if len(d.Parts) == 0 {
+		return nil
+	}
+
+	return d.Parts[0].Metadata()
+}
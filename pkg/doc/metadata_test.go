@@ -0,0 +1,70 @@
+package doc
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentMetadata(t *testing.T) {
+	d, err := ReadDocument(bytes.NewBufferString(`apiVersion: modden/v1alpha1
+kind: Document
+metadata:
+  name: httpbin-status
+  description: Verify the httpbin proxy.
+  tags: [smoke, httpproxy]
+  owners: [jpeach]
+spec:
+  params: [proxy-address]
+  checkTimeout: 60s
+  fixtures: [fixtures/]
+---
+error[msg] { msg := "fail" }
+`))
+	require.NoError(t, err)
+
+	for i := range d.Parts {
+		_, err := d.Parts[i].Decode()
+		require.NoError(t, err)
+	}
+
+	want := &Metadata{
+		Name:         "httpbin-status",
+		Description:  "Verify the httpbin proxy.",
+		Tags:         []string{"smoke", "httpproxy"},
+		Owners:       []string{"jpeach"},
+		Params:       []string{"proxy-address"},
+		CheckTimeout: time.Minute,
+		Fixtures:     []string{"fixtures/"},
+	}
+
+	assert.Equal(t, want, d.Metadata())
+
+	assert.Equal(t, map[string]interface{}{
+		"name":         "httpbin-status",
+		"description":  "Verify the httpbin proxy.",
+		"tags":         []interface{}{"smoke", "httpproxy"},
+		"owners":       []interface{}{"jpeach"},
+		"params":       []interface{}{"proxy-address"},
+		"checkTimeout": "1m0s",
+		"fixtures":     []interface{}{"fixtures/"},
+	}, d.Metadata().Properties())
+
+	// Unknown fields are errors.
+	f := Fragment{Bytes: []byte(`apiVersion: modden/v1alpha1
+kind: Document
+metadata:
+  nmae: typo
+`)}
+
+	fragType, err := f.Decode()
+	assert.Error(t, err)
+	assert.EqualValues(t, FragmentTypeInvalid, fragType)
+
+	// Metadata must lead.
+	d.Parts = d.Parts[1:]
+	assert.Nil(t, d.Metadata())
+}
@@ -3,9 +3,12 @@ package doc
 import (
 	"bufio"
 	"bytes"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/utils"
@@ -24,7 +27,10 @@ func ReadDocument(in io.Reader) (*Document, error) {
 	startLine := 0
 	currentLine := 0
 
-	yamlSeparator := regexp.MustCompile("^---[\t\f\r ]*$")
+	// The separator may be followed by a comment, which can be
+	// a type marker for the following fragment.
+	yamlSeparator := regexp.MustCompile("^---[\t\f\r ]*(#.*)?$")
+	marker := ""
 
 	buf := bytes.Buffer{}
 	doc := Document{}
@@ -34,23 +40,21 @@ func ReadDocument(in io.Reader) (*Document, error) {
 	// Scan the input a line at a time.
 	for scanner.Scan() {
 		currentLine++
-		if startLine == 0 {
-			startLine = currentLine
-		}
 
 		// We just read another line, so replace the newline separator.
 		if buf.Len() > 0 {
 			must.Int(buf.WriteString("\n"))
 		}
 
-		if yamlSeparator.Match(scanner.Bytes()) {
+		if m := yamlSeparator.FindSubmatch(scanner.Bytes()); m != nil {
 			// Fragment must be at least one line long.
 			// If we kept empty fragments, then we would
 			// not be able to sel the line counts properly,
 			// since YAML separators are not included.
 			if buf.Len() > 0 {
 				doc.Parts = append(doc.Parts, Fragment{
-					Bytes: utils.CopyBytes(buf.Bytes()),
+					Bytes:  utils.CopyBytes(buf.Bytes()),
+					Marker: marker,
 					Location: Location{
 						Start: startLine,
 						End:   currentLine - 1,
@@ -58,18 +62,25 @@ func ReadDocument(in io.Reader) (*Document, error) {
 				})
 			}
 
-			startLine = 0
+			marker = parseMarker(string(m[1]))
 			buf.Truncate(0)
 			continue
 		}
 
+		// Leading blank lines are not written to the buffer,
+		// so the fragment starts at the first line that is.
+		if buf.Len() == 0 {
+			startLine = currentLine
+		}
+
 		must.Int(buf.Write(scanner.Bytes()))
 	}
 
 	// Append any data from the last separator up until EOF.
 	if buf.Len() > 0 {
 		doc.Parts = append(doc.Parts, Fragment{
-			Bytes: utils.CopyBytes(buf.Bytes()),
+			Bytes:  utils.CopyBytes(buf.Bytes()),
+			Marker: marker,
 			Location: Location{
 				Start: startLine,
 				End:   currentLine,
@@ -84,8 +95,83 @@ func ReadDocument(in io.Reader) (*Document, error) {
 	return &doc, nil
 }
 
-// ReadFile reads a Document from the given file path.
+// IncludeKey is the special key that marks a Fragment as including
+// the Fragments of another Document.
+const IncludeKey = "$include"
+
+// decodeInclude returns the document paths included by the fragment
+// data. The fragment must be a YAML object with a single include key
+// whose value is a path or a list of paths.
+func decodeInclude(data []byte) ([]string, bool) {
+	u, err := decodeYAMLOrJSON(data)
+	if err != nil || len(u.Object) != 1 {
+		return nil, false
+	}
+
+	switch val := u.Object[IncludeKey].(type) {
+	case string:
+		return []string{val}, true
+	default:
+		return utils.AsStringSlice(val)
+	}
+}
+
+// isMetadataFragment returns whether the fragment data is document
+// metadata.
+func isMetadataFragment(f *Fragment) bool {
+	if f.Marker == MarkerRego {
+		return false
+	}
+
+	u, err := decodeYAMLOrJSON(f.Bytes)
+	return err == nil && isMetadata(u)
+}
+
+// isBlankFragment returns whether the fragment data contains only
+// blank lines and comments. Fragments with a type marker are never
+// blank, since the marker comment makes the fragment type explicit.
+func isBlankFragment(f *Fragment) bool {
+	if f.Marker != "" || headerMarker(f.Bytes) != "" {
+		return false
+	}
+
+	for _, line := range strings.Split(string(f.Bytes), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReadFile reads a Document from the given file path. Markdown
+// files (with a ".md" or ".markdown" extension) are read with
+// ReadMarkdown, and all other files with ReadDocument. Unmarked
+// fragments that contain only blank lines and comments are dropped
+// if they come before any other fragment, so that a document can
+// begin with a comment block. Each fragment that
+// includes other Documents is replaced by the Fragments of those
+// Documents. Included paths are relative to the directory of the
+// including Document. Since only the including Document can have
+// metadata, the metadata of included Documents is dropped.
 func ReadFile(filePath string) (*Document, error) {
+	return readFile(filePath, nil)
+}
+
+func readFile(filePath string, including []string) (*Document, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range including {
+		if p == absPath {
+			cycle := append(utils.CopyStrings(including[i:]), absPath)
+			return nil, fmt.Errorf("include cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
 	fh, err := os.OpenFile(filePath, os.O_RDONLY, 0)
 	if err != nil {
 		return nil, err
@@ -93,11 +179,62 @@ func ReadFile(filePath string) (*Document, error) {
 
 	defer fh.Close()
 
-	doc, err := ReadDocument(fh)
+	read := ReadDocument
+	if isMarkdown(filePath) {
+		read = ReadMarkdown
+	}
+
+	doc, err := read(fh)
 	if err != nil {
 		return nil, err
 	}
 
 	doc.Name = filePath
+
+	parts := make([]Fragment, 0, len(doc.Parts))
+	including = append(utils.CopyStrings(including), absPath)
+
+	// leading is true until we see the first metadata or
+	// content fragment.
+	leading := true
+
+	for _, p := range doc.Parts {
+		p.Location.File = filePath
+
+		if leading && isBlankFragment(&p) {
+			continue
+		}
+
+		leading = false
+
+		includes, ok := decodeInclude(p.Bytes)
+		if !ok {
+			parts = append(parts, p)
+			continue
+		}
+
+		for _, inc := range includes {
+			if !filepath.IsAbs(inc) {
+				inc = filepath.Join(filepath.Dir(filePath), inc)
+			}
+
+			included, err := readFile(inc, including)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: failed to include %q: %w",
+					filePath, p.Location.Start, inc, err)
+			}
+
+			for _, ip := range included.Parts {
+				if isMetadataFragment(&ip) {
+					continue
+				}
+
+				ip.Location.Included = true
+				parts = append(parts, ip)
+			}
+		}
+	}
+
+	doc.Parts = parts
 	return doc, nil
 }
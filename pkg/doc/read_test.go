@@ -2,10 +2,16 @@ package doc
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestReadDocument(t *testing.T) {
@@ -101,4 +107,180 @@ b`,
 		},
 	})
 
+	// Fragments start at their first non-blank line, since leading
+	// blank lines are not part of the fragment.
+	run(t, "leading blank lines", testcase{
+		Data: `
+a
+---
+
+b
+
+---
+
+
+c`,
+		Want: Document{
+			Parts: []Fragment{
+				{Bytes: []byte("a\n"), Location: Location{Start: 2, End: 2}},
+				{Bytes: []byte("b\n\n"), Location: Location{Start: 5, End: 6}},
+				{Bytes: []byte("c"), Location: Location{Start: 10, End: 10}},
+			},
+		},
+	})
+
+}
+
+func TestReadFileInclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "include")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+		return p
+	}
+
+	main := write("main.yaml", `# A leading comment block.
+
+---
+a
+---
+$include: common/setup.yaml
+---
+d`)
+
+	setup := write("common/setup.yaml", `apiVersion: modden/v1alpha1
+kind: Document
+metadata:
+  name: setup
+---
+b
+---
+$include:
+- ../other.yaml
+`)
+
+	other := write("other.yaml", `# other
+c`)
+
+	got, err := ReadFile(main)
+	require.NoError(t, err)
+
+	want := &Document{
+		Name: main,
+		Parts: []Fragment{
+			{Bytes: []byte("a\n"), Location: Location{File: main, Start: 4, End: 4}},
+			{Bytes: []byte("b\n"), Location: Location{File: setup, Included: true, Start: 6, End: 6}},
+			{Bytes: []byte("# other\nc"), Location: Location{File: filepath.Join(dir, "common/../other.yaml"), Included: true, Start: 1, End: 2}},
+			{Bytes: []byte("d"), Location: Location{File: main, Start: 8, End: 8}},
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(Fragment{})); diff != "" {
+		t.Fatalf(diff)
+	}
+
+	assert.FileExists(t, other)
+
+	// Only included fragments are qualified by their file name.
+	assert.Equal(t, "4-4", got.Parts[0].Location.String())
+	assert.Equal(t, setup+":6-6", got.Parts[1].Location.String())
+
+	// Metadata from the included document was dropped, so the
+	// main document can still have its own metadata.
+	for i := range got.Parts {
+		assert.False(t, isMetadataFragment(&got.Parts[i]))
+	}
+
+	// Include cycles are errors.
+	write("cycle/one.yaml", `$include: two.yaml`)
+	write("cycle/two.yaml", `$include: [three.yaml]`)
+	write("cycle/three.yaml", `$include: one.yaml`)
+
+	_, err = ReadFile(filepath.Join(dir, "cycle/one.yaml"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "include cycle")
+
+	// Missing includes are errors.
+	write("missing.yaml", `$include: nothing.yaml`)
+	_, err = ReadFile(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestReadDocumentMarkers(t *testing.T) {
+	input := `a
+--- # modden: rego
+b
+--- # some comment
+c
+---
+# modden: yaml
+d`
+
+	got, err := ReadDocument(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	want := &Document{
+		Parts: []Fragment{
+			{Bytes: []byte("a\n"), Location: Location{Start: 1, End: 1}},
+			{Bytes: []byte("b\n"), Marker: MarkerRego, Location: Location{Start: 3, End: 3}},
+			{Bytes: []byte("c\n"), Location: Location{Start: 5, End: 5}},
+			{Bytes: []byte("# modden: yaml\nd"), Location: Location{Start: 7, End: 8}},
+		},
+	}
+
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreUnexported(Fragment{})); diff != "" {
+		t.Fatalf(diff)
+	}
+}
+
+func TestReadFileCommentFragments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "comments")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`# A leading comment block.
+---
+# Another leading comment.
+---
+apiVersion: modden/v1alpha1
+kind: Document
+---
+# A comment after the metadata.
+---
+# modden: rego
+# A marked fragment with no rules.
+`), 0644))
+
+	got, err := ReadFile(path)
+	require.NoError(t, err)
+
+	// Only the leading comment fragments are dropped.
+	require.Len(t, got.Parts, 3)
+	assert.True(t, isMetadataFragment(&got.Parts[0]))
+	assert.Equal(t, 8, got.Parts[1].Location.Start)
+	assert.Equal(t, 10, got.Parts[2].Location.Start)
+
+	// The marked fragment is still decoded as Rego, so the
+	// missing rules are an error.
+	fragType, err := got.Parts[2].Decode()
+	assert.Error(t, err)
+	assert.EqualValues(t, FragmentTypeInvalid, fragType)
+
+	// A marked fragment is not dropped, even at the start of
+	// the document.
+	require.NoError(t, ioutil.WriteFile(path, []byte(`# modden: rego
+# No rules here either.
+`), 0644))
+
+	got, err = ReadFile(path)
+	require.NoError(t, err)
+	require.Len(t, got.Parts, 1)
+	assert.Equal(t, MarkerRego, headerMarker(got.Parts[0].Bytes))
 }
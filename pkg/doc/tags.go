@@ -0,0 +1,70 @@
+package doc
+
+import (
+	"strings"
+)
+
+// TagSelector selects Documents by the tags in their Metadata.
+//
+// Each selector entry is a tag expression, which is one or more
+// tags joined by '+'. A tag expression matches a Document that has
+// all of the joined tags.
+type TagSelector struct {
+	// Include selects Documents that match any of these
+	// expressions. If there are no expressions, all
+	// Documents are included.
+	Include []string
+
+	// Exclude rejects Documents that match any of these
+	// expressions, even if they would otherwise be included.
+	Exclude []string
+}
+
+func matchTagExpression(expr string, tags []string) bool {
+	for _, want := range strings.Split(expr, "+") {
+		found := false
+
+		for _, t := range tags {
+			if t == strings.TrimSpace(want) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Matches returns whether a Document with the given tags is selected.
+func (s *TagSelector) Matches(tags []string) bool {
+	for _, expr := range s.Exclude {
+		if matchTagExpression(expr, tags) {
+			return false
+		}
+	}
+
+	if len(s.Include) == 0 {
+		return true
+	}
+
+	for _, expr := range s.Include {
+		if matchTagExpression(expr, tags) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Tags returns the tags from the Document Metadata, if there are any.
+func (d *Document) Tags() []string {
+	if m := d.Metadata(); m != nil {
+		return m.Tags
+	}
+
+	return nil
+}
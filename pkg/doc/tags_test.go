@@ -0,0 +1,33 @@
+package doc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagSelector(t *testing.T) {
+	all := TagSelector{}
+	assert.True(t, all.Matches(nil))
+	assert.True(t, all.Matches([]string{"smoke"}))
+
+	smoke := TagSelector{Include: []string{"smoke"}}
+	assert.False(t, smoke.Matches(nil))
+	assert.True(t, smoke.Matches([]string{"slow", "smoke"}))
+
+	either := TagSelector{Include: []string{"smoke", "conformance"}}
+	assert.True(t, either.Matches([]string{"conformance"}))
+	assert.False(t, either.Matches([]string{"slow"}))
+
+	both := TagSelector{Include: []string{"smoke+conformance"}}
+	assert.False(t, both.Matches([]string{"conformance"}))
+	assert.True(t, both.Matches([]string{"smoke", "conformance"}))
+
+	notSlow := TagSelector{Exclude: []string{"slow"}}
+	assert.True(t, notSlow.Matches(nil))
+	assert.False(t, notSlow.Matches([]string{"smoke", "slow"}))
+
+	smokeNotSlow := TagSelector{Include: []string{"smoke"}, Exclude: []string{"slow"}}
+	assert.True(t, smokeNotSlow.Matches([]string{"smoke"}))
+	assert.False(t, smokeNotSlow.Matches([]string{"smoke", "slow"}))
+}
@@ -0,0 +1,48 @@
+package driver
+
+import (
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/cover"
+	"github.com/open-policy-agent/opa/topdown"
+)
+
+// RegoCoverage is a tracer that records which lines of Rego were
+// evaluated. Coverage is aggregated across every evaluation that
+// is traced, and a RegoCoverage can be shared by many RegoDrivers.
+type RegoCoverage struct {
+	lock  sync.Mutex
+	cover *cover.Cover
+}
+
+var _ topdown.Tracer = &RegoCoverage{}
+
+// NewRegoCoverage returns a new RegoCoverage tracer.
+func NewRegoCoverage() *RegoCoverage {
+	return &RegoCoverage{
+		cover: cover.New(),
+	}
+}
+
+// Enabled ...
+func (c *RegoCoverage) Enabled() bool {
+	return true
+}
+
+// Trace ...
+func (c *RegoCoverage) Trace(event *topdown.Event) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.cover.Trace(event)
+}
+
+// Report returns the OPA coverage report for the given modules,
+// which are keyed by their file names.
+func (c *RegoCoverage) Report(modules map[string]*ast.Module) cover.Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.cover.Report(modules)
+}
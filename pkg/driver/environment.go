@@ -2,6 +2,7 @@ package driver
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/jpeach/modden/pkg/doc"
 	"github.com/jpeach/modden/pkg/filter"
@@ -23,19 +24,36 @@ type Environment interface {
 
 	// HydrateObject ...
 	HydrateObject(objData []byte) (*Object, error)
+
+	// HydrateObjects hydrates YAML data that may refer to a group
+	// of fixture objects.
+	HydrateObjects(objData []byte) ([]*Object, error)
+
+	// HydrateObjectsAt hydrates YAML data that was read from the
+	// given location in a test document. Any "$check" Rego refers
+	// to its location in the document.
+	HydrateObjectsAt(loc doc.Location, objData []byte) ([]*Object, error)
 }
 
-// NewEnvironment returns a new Environment.
-func NewEnvironment() Environment {
+// NewEnvironment returns a new Environment that hydrates fixture
+// objects from the given FixtureSet. If fixtures is nil, the
+// Environment has no fixtures.
+func NewEnvironment(fixtures fixture.FixtureSet) Environment {
+	if fixtures == nil {
+		fixtures = fixture.NewSet()
+	}
+
 	return &environ{
-		uid: uuid.New().String(),
+		uid:      uuid.New().String(),
+		fixtures: fixtures,
 	}
 }
 
 var _ Environment = &environ{}
 
 type environ struct {
-	uid string
+	uid      string
+	fixtures fixture.FixtureSet
 }
 
 // UniqueID returns a unique identifier for this Environment instance.
@@ -53,12 +71,108 @@ const (
 	// ObjectOperationUpdate indicates this object should be
 	// updated (i.e created or patched).
 	ObjectOperationUpdate = "update"
+	// ObjectOperationExpect indicates this object is a partial
+	// object that the live object is expected to match.
+	ObjectOperationExpect = "expect"
 )
 
 // Fixture is a marker to tell the Environment that a Kubernetes
 // object is a fixture placeholder.
 type Fixture struct {
-	As string
+	As     string
+	Group  string
+	Rename string
+
+	// References are additional reference paths, keyed by
+	// object kind, that are rewritten when the fixture is
+	// renamed with FixtureRenameReferences. Paths are field
+	// names separated by dots, e.g. "spec.backendRef.name".
+	References map[string][]string
+}
+
+const (
+	// FixtureRenameName renames only the fixture object.
+	FixtureRenameName = "name"
+	// FixtureRenameReferences renames the fixture object and
+	// the fields that refer to it by name.
+	FixtureRenameReferences = "references"
+)
+
+// validate checks that the fixture rename options are supported.
+func (fix Fixture) validate() error {
+	switch fix.Rename {
+	case "", FixtureRenameName:
+		if len(fix.References) > 0 {
+			return fmt.Errorf("fixture references require %q rename", FixtureRenameReferences)
+		}
+	case FixtureRenameReferences:
+	default:
+		return fmt.Errorf("unsupported fixture rename %q", fix.Rename)
+	}
+
+	return nil
+}
+
+// referenceTable returns the reference table to use when renaming
+// references, or nil to use the default table.
+func (fix Fixture) referenceTable() filter.ReferenceTable {
+	if len(fix.References) == 0 {
+		return nil
+	}
+
+	extra := filter.ReferenceTable{}
+	for kind, paths := range fix.References {
+		for _, p := range paths {
+			extra[kind] = append(extra[kind], filter.ParseReferencePath(p))
+		}
+	}
+
+	return filter.DefaultReferenceTable.Merge(extra)
+}
+
+// rename applies the fixture's new name to f.
+func (fix Fixture) rename(f fixture.Fixture) (fixture.Fixture, error) {
+	if err := fix.validate(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case fix.As == "":
+		return f, nil
+	case fix.Rename == FixtureRenameReferences:
+		return f.RenameReferences(fix.As, fix.referenceTable())
+	default:
+		return f.Rename(fix.As)
+	}
+}
+
+// renameGroup applies the fixture's new name to every member of
+// a fixture group.
+func (fix Fixture) renameGroup(members []fixture.Fixture) ([]fixture.Fixture, error) {
+	if err := fix.validate(); err != nil {
+		return nil, err
+	}
+
+	if fix.As != "" && fix.Rename == FixtureRenameReferences {
+		members, err := fixture.RenameGroup(members, fix.As, fix.referenceTable())
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename fixture group: %w", err)
+		}
+
+		return members, nil
+	}
+
+	renamed := make([]fixture.Fixture, 0, len(members))
+	for _, m := range members {
+		m, err := fix.rename(m)
+		if err != nil {
+			return nil, fmt.Errorf("failed to rename fixture object: %w", err)
+		}
+
+		renamed = append(renamed, m)
+	}
+
+	return renamed, nil
 }
 
 // Object captures an Unstructured Kubernetes API object and its
@@ -72,6 +186,14 @@ type Object struct {
 	// Check is a Rego check to run on the apply.
 	Check *ast.Module
 
+	// CheckLocation is the location of the check in the test
+	// document, if it is known.
+	CheckLocation doc.Location
+
+	// Trace specifies whether the check should be traced. This
+	// is derived from the "$trace" pseudo-field.
+	Trace bool
+
 	// Operation specifies whether we are updating or deleting the object.
 	Operation ObjectOperationType
 
@@ -93,19 +215,59 @@ func yamlToUnstructured(node *yaml.RNode) (*unstructured.Unstructured, error) {
 	return resource.(*unstructured.Unstructured), nil
 }
 
-func matchFixture(resource *yaml.RNode) fixture.Fixture {
+func (e *environ) matchFixture(resource *yaml.RNode) fixture.Fixture {
 	u := must.Unstructured(yamlToUnstructured(resource))
 
-	if match := fixture.Set.Match(u); match != nil {
+	if match := e.fixtures.Match(u); match != nil {
 		return match
 	}
 
 	return nil
 }
 
+// noFixtureErr returns an error for a resource that doesn't match
+// any fixture, suggesting the fixtures that it most closely resembles.
+func (e *environ) noFixtureErr(resource *yaml.RNode) error {
+	key := fixture.KeyFor(must.Unstructured(yamlToUnstructured(resource)))
+
+	var suggestions []string
+	for _, k := range fixture.Closest(e.fixtures, key, 3) {
+		suggestions = append(suggestions, k.String())
+	}
+
+	if len(suggestions) == 0 {
+		return fmt.Errorf("failed to match fixture %s", key)
+	}
+
+	return fmt.Errorf("failed to match fixture %s (did you mean %s?)",
+		key, strings.Join(suggestions, ", "))
+}
+
 // HydrateObject unmarshals YAML data into a unstructured.Unstructured
 // object, applying any defaults and expanding templates.
 func (e *environ) HydrateObject(objData []byte) (*Object, error) {
+	objs, err := e.HydrateObjects(objData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(objs) != 1 {
+		return nil, fmt.Errorf("expected 1 object, but hydrated %d", len(objs))
+	}
+
+	return objs[0], nil
+}
+
+// HydrateObjects unmarshals YAML data into one or more objects. A
+// single Kubernetes object hydrates to one object, and a fixture group
+// reference hydrates to an object for each member of the group.
+func (e *environ) HydrateObjects(objData []byte) ([]*Object, error) {
+	return e.HydrateObjectsAt(doc.Location{}, objData)
+}
+
+// HydrateObjectsAt unmarshals YAML data that was read from the given
+// location into one or more objects.
+func (e *environ) HydrateObjectsAt(loc doc.Location, objData []byte) ([]*Object, error) {
 	// TODO(jpeach): before parsing YAML, apply Go template context.
 
 	resource, err := yaml.Parse(string(objData))
@@ -114,38 +276,108 @@ func (e *environ) HydrateObject(objData []byte) (*Object, error) {
 	}
 
 	// Filter out any special operations.
-	ops := newSpecialOpsFilter()
+	ops := newSpecialOpsFilter(loc)
 
 	resource, err = resource.Pipe(ops)
 	if err != nil {
 		return nil, fmt.Errorf("special ops filtering: %w", err)
 	}
 
+	// Reject any special operations that we don't know how to
+	// handle, since these are most likely typos.
+	for key := range ops.Ops {
+		if _, ok := specialOpHandlers[key]; !ok {
+			return nil, fmt.Errorf("unsupported special operation %q", key)
+		}
+	}
+
 	// Before we make any modifications to the object we just
 	// parsed, check if we need to replace it with a fixture.
 	if val, ok := ops.Ops["$apply"]; ok {
 		if fix, ok := val.(Fixture); ok {
-			match := matchFixture(resource)
+			if fix.Group != "" {
+				return e.hydrateGroup(fix, resource, ops)
+			}
+
+			match := e.matchFixture(resource)
 			if match == nil {
-				return nil, fmt.Errorf("failed to match fixture")
+				return nil, e.noFixtureErr(resource)
+			}
+
+			match, err = fix.rename(match)
+			if err != nil {
+				return nil, fmt.Errorf("failed to rename fixture object: %w", err)
 			}
 
-			if fix.As != "" {
-				match, err = match.Rename(fix.As)
-				if err != nil {
-					return nil, fmt.Errorf("failed to rename fixture object: %w", err)
-				}
+			// Any other fields in the fragment are
+			// overlaid onto the fixture.
+			overlay, err := yamlToUnstructured(resource)
+			if err != nil {
+				return nil, err
+			}
+
+			match, err = match.Merge(overlay)
+			if err != nil {
+				return nil, fmt.Errorf("failed to merge fixture object: %w", err)
 			}
 
 			resource = match.AsNode()
 		}
 	}
 
-	// Inject test metadata.
-	resource, err = resource.Pipe(
-		&filter.MetaInjectionFilter{RunID: e.UniqueID(), ManagedBy: version.Progname})
+	o, err := e.hydrate(resource, ops)
+	if err != nil {
+		return nil, err
+	}
+
+	return []*Object{o}, nil
+}
+
+// hydrateGroup hydrates each member of a fixture group, giving
+// each member the same new name.
+func (e *environ) hydrateGroup(fix Fixture, resource *yaml.RNode, ops *filter.SpecialOpsFilter) ([]*Object, error) {
+	// A group has no single object to overlay fields onto.
+	if fields, err := resource.Fields(); err != nil || len(fields) > 0 {
+		return nil, fmt.Errorf("fixture group %q reference must not have object fields", fix.Group)
+	}
+
+	members := e.fixtures.Group(fix.Group)
+	if len(members) == 0 {
+		return nil, fmt.Errorf("failed to match fixture group %q", fix.Group)
+	}
+
+	members, err := fix.renameGroup(members)
 	if err != nil {
-		return nil, fmt.Errorf("metadata injection failed: %w", err)
+		return nil, err
+	}
+
+	var objs []*Object
+
+	for _, m := range members {
+		o, err := e.hydrate(m.AsNode(), ops)
+		if err != nil {
+			return nil, err
+		}
+
+		objs = append(objs, o)
+	}
+
+	return objs, nil
+}
+
+// hydrate injects the test metadata into the resource and applies
+// the special operations to the resulting Object.
+func (e *environ) hydrate(resource *yaml.RNode, ops *filter.SpecialOpsFilter) (*Object, error) {
+	var err error
+
+	// Inject test metadata. Expectations describe the state of
+	// some existing object, so we must not add anything to them.
+	if val := ops.Ops["$apply"]; val != ObjectOperationExpect {
+		resource, err = resource.Pipe(
+			&filter.MetaInjectionFilter{RunID: e.UniqueID(), ManagedBy: version.Progname})
+		if err != nil {
+			return nil, fmt.Errorf("metadata injection failed: %w", err)
+		}
 	}
 
 	o := Object{
@@ -172,7 +404,38 @@ func (e *environ) HydrateObject(objData []byte) (*Object, error) {
 	return &o, nil
 }
 
-func newSpecialOpsFilter() *filter.SpecialOpsFilter {
+// checkSource is the Rego from a "$check" field, along with its
+// location in the test document.
+type checkSource struct {
+	data     string
+	location doc.Location
+}
+
+// checkLocation returns the location of the "$check" field value
+// in the test document, given the location of the fragment that the
+// object was read from. If the fragment location is unknown, so is
+// the check location.
+func checkLocation(loc doc.Location, n *yaml.Node) doc.Location {
+	if loc.Start <= 0 {
+		return doc.Location{}
+	}
+
+	// The node line is relative to the start of the fragment. A
+	// block scalar starts on the line after its indicator.
+	line := loc.Start + n.Line - 1
+	if n.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+		line++
+	}
+
+	return doc.Location{
+		File:     loc.File,
+		Included: loc.Included,
+		Start:    line,
+		End:      line + strings.Count(strings.TrimSuffix(n.Value, "\n"), "\n"),
+	}
+}
+
+func newSpecialOpsFilter(loc doc.Location) *filter.SpecialOpsFilter {
 	// Filter out any special operations.
 	ops := filter.SpecialOpsFilter{
 		Decoders: map[string]yaml.Unmarshaler{},
@@ -188,6 +451,10 @@ func newSpecialOpsFilter() *filter.SpecialOpsFilter {
 		//	$apply:
 		//	  fixture:
 		//	    as: some-other-name
+		//	    group: some-fixture-group
+		//	    rename: references
+		//	    references:
+		//	      SomeKind: [spec.some.field]
 
 		if err := n.Decode(&as); err == nil {
 			ops.Ops["$apply"] = as.Fixture
@@ -208,24 +475,63 @@ func newSpecialOpsFilter() *filter.SpecialOpsFilter {
 		return fmt.Errorf("unable to decode YAML field %q", "$apply")
 	})
 
+	ops.Decoders["$check"] = filter.UnmarshalFunc(func(n *yaml.Node) error {
+		var str string
+
+		if err := n.Decode(&str); err != nil {
+			return fmt.Errorf("unable to decode YAML field %q", "$check")
+		}
+
+		ops.Ops["$check"] = checkSource{
+			data:     str,
+			location: checkLocation(loc, n),
+		}
+
+		return nil
+	})
+
+	ops.Decoders["$trace"] = filter.UnmarshalFunc(func(n *yaml.Node) error {
+		var trace bool
+
+		if err := n.Decode(&trace); err != nil {
+			return fmt.Errorf("unable to decode YAML field %q", "$trace")
+		}
+
+		ops.Ops["$trace"] = trace
+		return nil
+	})
+
 	return &ops
 }
 
 var specialOpHandlers = map[string]func(val interface{}, o *Object) error{
 	"$check": func(val interface{}, o *Object) error {
-		strval, ok := val.(string)
+		src, ok := val.(checkSource)
 		if !ok {
 			return fmt.Errorf(
 				"failed to decode %q field: unexpected type %T",
-				"$check", strval)
+				"$check", val)
 		}
 
-		frag, err := doc.NewRegoFragment([]byte(strval))
+		frag, err := doc.NewRegoFragmentAt(src.location, []byte(src.data))
 		if err != nil {
 			return err
 		}
 
 		o.Check = frag.Rego()
+		o.CheckLocation = src.location
+		return nil
+	},
+
+	"$trace": func(val interface{}, o *Object) error {
+		trace, ok := val.(bool)
+		if !ok {
+			return fmt.Errorf(
+				"failed to decode %q field: unexpected type %T",
+				"$trace", val)
+		}
+
+		o.Trace = trace
 		return nil
 	},
 
@@ -237,6 +543,8 @@ var specialOpHandlers = map[string]func(val interface{}, o *Object) error{
 				o.Operation = ObjectOperationUpdate
 			case "delete":
 				o.Operation = ObjectOperationDelete
+			case "expect":
+				o.Operation = ObjectOperationExpect
 			case "fixture":
 				o.Operation = ObjectOperationUpdate
 			default:
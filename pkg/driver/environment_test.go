@@ -0,0 +1,383 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/jpeach/modden/pkg/doc"
+	"github.com/jpeach/modden/pkg/fixture"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestHydrateFixtureOverlay(t *testing.T) {
+	f := fixture.Fixture(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: overlay
+spec:
+  replicas: 1
+`)
+
+	fixtures := fixture.NewSet()
+	require.NoError(t, fixtures.Insert(fixture.NewEntry(f, "")))
+
+	env := NewEnvironment(fixtures)
+
+	obj, err := env.HydrateObject([]byte(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: overlay
+spec:
+  replicas: 3
+$apply:
+  fixture:
+    as: renamed
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "renamed", obj.Object.GetName())
+	assert.Equal(t, env.UniqueID(), obj.Object.GetAnnotations()["modden/run-id"])
+
+	replicas, _, _ := unstructured.NestedInt64(obj.Object.Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+}
+
+func TestHydrateFixtureMissing(t *testing.T) {
+	// Fixtures in one environment are not visible in another.
+	f := fixture.Fixture(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: scoped
+`)
+
+	fixtures := fixture.NewSet()
+	require.NoError(t, fixtures.Insert(fixture.NewEntry(f, "")))
+
+	hydrate := func(env Environment) error {
+		_, err := env.HydrateObject([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: scoped
+$apply: fixture
+`))
+		return err
+	}
+
+	assert.NoError(t, hydrate(NewEnvironment(fixtures)))
+	assert.EqualError(t, hydrate(NewEnvironment(nil)),
+		"failed to match fixture v1:ConfigMap 'scoped'")
+
+	// Match failures suggest similar fixtures.
+	_, err := NewEnvironment(fixtures).HydrateObject([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: scope
+$apply: fixture
+`))
+	assert.EqualError(t, err,
+		"failed to match fixture v1:ConfigMap 'scope' (did you mean v1:ConfigMap 'scoped'?)")
+}
+
+func TestHydrateFixtureGroup(t *testing.T) {
+	fixtures := fixture.NewSet()
+
+	for _, f := range []fixture.Fixture{
+		fixture.Fixture("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: httpbin\n"),
+		fixture.Fixture("apiVersion: v1\nkind: Service\nmetadata:\n  name: httpbin\n"),
+	} {
+		require.NoError(t, fixtures.Insert(fixture.NewEntry(f, "", "httpbin")))
+	}
+
+	env := NewEnvironment(fixtures)
+
+	objs, err := env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    as: test/echo
+`))
+	require.NoError(t, err)
+	require.Len(t, objs, 2)
+
+	for i, kind := range []string{"Deployment", "Service"} {
+		assert.Equal(t, kind, objs[i].Object.GetKind())
+		assert.Equal(t, "echo", objs[i].Object.GetName())
+		assert.Equal(t, "test", objs[i].Object.GetNamespace())
+		assert.Equal(t, env.UniqueID(), objs[i].Object.GetAnnotations()["modden/run-id"])
+	}
+
+	// Groups can't be hydrated to a single object.
+	_, err = env.HydrateObject([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+`))
+	assert.Error(t, err)
+
+	// Groups must exist.
+	_, err = env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: missing
+`))
+	assert.Error(t, err)
+
+	// Groups can't have overlay fields.
+	_, err = env.HydrateObjects([]byte(`
+spec:
+  replicas: 2
+$apply:
+  fixture:
+    group: httpbin
+`))
+	assert.Error(t, err)
+}
+
+func TestHydrateFixtureRenameReferences(t *testing.T) {
+	fixtures := fixture.NewSet()
+
+	for _, f := range []fixture.Fixture{
+		fixture.Fixture(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+spec:
+  selector:
+    matchLabels:
+      app: httpbin
+  template:
+    metadata:
+      labels:
+        app: httpbin
+    spec:
+      containers:
+      - name: httpbin
+        envFrom:
+        - configMapRef:
+            name: httpbin-config
+`),
+		fixture.Fixture(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+spec:
+  selector:
+    app: httpbin
+`),
+		fixture.Fixture(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: httpbin-config
+`),
+		fixture.Fixture(`
+apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: httpbin-proxy
+spec:
+  routes:
+  - services:
+    - name: httpbin
+      port: 80
+`),
+		fixture.Fixture(`
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: httpbin-widget
+spec:
+  target:
+    service: httpbin
+`),
+	} {
+		require.NoError(t, fixtures.Insert(fixture.NewEntry(f, "", "httpbin")))
+	}
+
+	env := NewEnvironment(fixtures)
+
+	objs, err := env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    as: echo
+    rename: references
+`))
+	require.NoError(t, err)
+	require.Len(t, objs, 5)
+
+	byKind := map[string]*unstructured.Unstructured{}
+	for _, o := range objs {
+		assert.Equal(t, "echo", o.Object.GetName())
+		byKind[o.Object.GetKind()] = o.Object
+	}
+
+	selector, _, _ := unstructured.NestedString(byKind["Deployment"].Object, "spec", "selector", "matchLabels", "app")
+	assert.Equal(t, "echo", selector)
+
+	selector, _, _ = unstructured.NestedString(byKind["Service"].Object, "spec", "selector", "app")
+	assert.Equal(t, "echo", selector)
+
+	// References to group members with other names are renamed too.
+	containers, _, _ := unstructured.NestedSlice(byKind["Deployment"].Object, "spec", "template", "spec", "containers")
+	require.Len(t, containers, 1)
+	envFrom := containers[0].(map[string]interface{})["envFrom"].([]interface{})
+	configMap, _, _ := unstructured.NestedString(envFrom[0].(map[string]interface{}), "configMapRef", "name")
+	assert.Equal(t, "echo", configMap)
+
+	routes, _, _ := unstructured.NestedSlice(byKind["HTTPProxy"].Object, "spec", "routes")
+	require.Len(t, routes, 1)
+	services := routes[0].(map[string]interface{})["services"].([]interface{})
+	service, _, _ := unstructured.NestedString(services[0].(map[string]interface{}), "name")
+	assert.Equal(t, "echo", service)
+
+	// Unknown kinds have no reference paths by default.
+	target, _, _ := unstructured.NestedString(byKind["Widget"].Object, "spec", "target", "service")
+	assert.Equal(t, "httpbin", target)
+
+	// Additional reference paths can be configured.
+	objs, err = env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    as: echo
+    rename: references
+    references:
+      Widget: [spec.target.service]
+`))
+	require.NoError(t, err)
+
+	for _, o := range objs {
+		if o.Object.GetKind() == "Widget" {
+			target, _, _ = unstructured.NestedString(o.Object.Object, "spec", "target", "service")
+			assert.Equal(t, "echo", target)
+		}
+	}
+
+	// References can only be configured when renaming references.
+	_, err = env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    as: echo
+    references:
+      Widget: [spec.target.service]
+`))
+	assert.Error(t, err)
+
+	// Without reference renaming, the selector is unchanged.
+	objs, err = env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    as: echo
+`))
+	require.NoError(t, err)
+
+	for _, o := range objs {
+		if o.Object.GetKind() == "Service" {
+			selector, _, _ = unstructured.NestedString(o.Object.Object, "spec", "selector", "app")
+			assert.Equal(t, "httpbin", selector)
+		}
+	}
+
+	_, err = env.HydrateObjects([]byte(`
+$apply:
+  fixture:
+    group: httpbin
+    rename: everything
+`))
+	assert.Error(t, err)
+}
+
+func TestHydrateTrace(t *testing.T) {
+	env := NewEnvironment(nil)
+
+	obj, err := env.HydrateObject([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: traced
+$trace: true
+`))
+	require.NoError(t, err)
+	assert.True(t, obj.Trace)
+
+	_, found, _ := unstructured.NestedFieldNoCopy(obj.Object.Object, "$trace")
+	assert.False(t, found)
+
+	_, err = env.HydrateObject([]byte(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: traced
+$trace: sometimes
+`))
+	assert.Error(t, err)
+}
+
+func TestHydrateCheckLocation(t *testing.T) {
+	env := NewEnvironment(nil)
+
+	// The fragment starts on line 10 of the document, so the
+	// check rule is on line 16.
+	objs, err := env.HydrateObjectsAt(doc.Location{File: "test.yaml", Start: 10, End: 18}, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: |
+  error[msg] {
+    msg := "failed"
+  }
+`))
+	require.NoError(t, err)
+	require.Len(t, objs, 1)
+
+	check := objs[0].Check
+	require.NotNil(t, check)
+	assert.Equal(t, "test.yaml", check.Rules[0].Location.File)
+	assert.Equal(t, 15, check.Rules[0].Location.Row)
+	assert.Equal(t, 16, check.Rules[0].Body[0].Location.Row)
+
+	// A single line check is on the same line as its field.
+	objs, err = env.HydrateObjectsAt(doc.Location{File: "test.yaml", Start: 10, End: 14}, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: 'error[msg] { msg := "failed" }'
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 14, objs[0].Check.Rules[0].Location.Row)
+
+	// Errors refer to the document location.
+	_, err = env.HydrateObjectsAt(doc.Location{File: "test.yaml", Start: 10, End: 17}, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: |
+  error[msg] {
+    msg := ]
+  }
+`))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "test.yaml:16")
+
+	// Without a location, the check location is internal.
+	obj, err := env.HydrateObject([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: 'error[msg] { msg := "failed" }'
+`))
+	require.NoError(t, err)
+	assert.Contains(t, obj.Check.Rules[0].Location.File, "internal/check/")
+}
@@ -0,0 +1,221 @@
+package driver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/jpeach/modden/pkg/doc"
+)
+
+// DefaultExecTimeout is the timeout for local commands that
+// don't specify one.
+const DefaultExecTimeout = time.Minute
+
+// ExecResult describes the result of running a local command.
+type ExecResult struct {
+	Command    string   `json:"command"`
+	Args       []string `json:"args"`
+	Dir        string   `json:"dir"`
+	Stdout     string   `json:"stdout"`
+	Stderr     string   `json:"stderr"`
+	ExitStatus int      `json:"exitStatus"`
+	TimedOut   bool     `json:"timedOut"`
+	Stopped    bool     `json:"stopped"`
+	StartTime  string   `json:"startTime"`
+	Duration   string   `json:"duration"`
+}
+
+// Succeeded returns true if the command exited with a zero status.
+// A background command that was stopped is considered to have
+// succeeded.
+func (e *ExecResult) Succeeded() bool {
+	if e.Stopped {
+		return true
+	}
+
+	return !e.TimedOut && e.ExitStatus == 0
+}
+
+// ExecDriver is a driver for running local commands.
+type ExecDriver interface {
+	// Run runs the given command to completion, capturing its
+	// output. An error is returned only if the command could not
+	// be started. Commands that fail or time out are reported in
+	// the ExecResult.
+	Run(*doc.Exec) (*ExecResult, error)
+
+	// Start starts the given command in the background. The
+	// command runs until it exits or is stopped.
+	Start(*doc.Exec) (*ExecProcess, error)
+}
+
+// NewExecDriver returns a new ExecDriver.
+func NewExecDriver() ExecDriver {
+	return &execDriver{}
+}
+
+type execDriver struct{}
+
+var _ ExecDriver = &execDriver{}
+
+// Run runs the given command to completion.
+func (e *execDriver) Run(what *doc.Exec) (*ExecResult, error) {
+	timeout := what.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+
+	p, err := startProcess(what)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-p.done:
+	case <-timer.C:
+		p.timedOut = true
+	}
+
+	// Kill any children of the command that are still running
+	// and holding its output open. Otherwise we would wait for
+	// them to exit, even after the command itself has exited.
+	p.kill()
+
+	return p.wait()
+}
+
+// Start starts the given command in the background.
+func (e *execDriver) Start(what *doc.Exec) (*ExecProcess, error) {
+	return startProcess(what)
+}
+
+// ExecProcess is a local command that is running in the background.
+type ExecProcess struct {
+	what   *doc.Exec
+	cmd    *exec.Cmd
+	stdout bytes.Buffer
+	stderr bytes.Buffer
+
+	startTime time.Time
+	endTime   time.Time
+	timedOut  bool
+	stopped   bool
+
+	done chan struct{}
+	err  error
+}
+
+func startProcess(what *doc.Exec) (*ExecProcess, error) {
+	p := &ExecProcess{
+		what: what,
+		done: make(chan struct{}),
+	}
+
+	p.cmd = exec.Command(what.Command, what.Args...)
+	p.cmd.Dir = what.Dir
+	p.cmd.Stdout = &p.stdout
+	p.cmd.Stderr = &p.stderr
+	p.cmd.Env = os.Environ()
+
+	// Sort the additional environment so that the command
+	// environment is deterministic.
+	keys := make([]string, 0, len(what.Env))
+	for k := range what.Env {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p.cmd.Env = append(p.cmd.Env, fmt.Sprintf("%s=%s", k, what.Env[k]))
+	}
+
+	// Run the command in its own process group so that we
+	// can kill all of its children along with it.
+	setProcessGroup(p.cmd)
+
+	p.startTime = time.Now()
+
+	if err := p.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		p.err = p.cmd.Wait()
+		p.endTime = time.Now()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// Command returns the name of the command.
+func (p *ExecProcess) Command() string {
+	return p.what.Command
+}
+
+// Exited returns true if the command has exited.
+func (p *ExecProcess) Exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Stop kills the command and all of its children, and returns the
+// result of the command. If the command had already exited, Stop
+// just returns its result.
+func (p *ExecProcess) Stop() (*ExecResult, error) {
+	p.stopped = !p.Exited()
+	p.kill()
+
+	return p.wait()
+}
+
+// kill kills every process in the command's process group. Since the
+// process group remains while any of its members are running, this
+// also kills children that outlived the command.
+func (p *ExecProcess) kill() {
+	// Errors are expected if the whole group already exited.
+	_ = killProcessGroup(p.cmd)
+}
+
+// wait waits for the command to exit and returns its result.
+func (p *ExecProcess) wait() (*ExecResult, error) {
+	<-p.done
+
+	res := ExecResult{
+		Command:    p.what.Command,
+		Args:       p.what.Args,
+		Dir:        p.what.Dir,
+		Stdout:     p.stdout.String(),
+		Stderr:     p.stderr.String(),
+		ExitStatus: p.cmd.ProcessState.ExitCode(),
+		TimedOut:   p.timedOut,
+		Stopped:    p.stopped,
+		StartTime:  p.startTime.Format(time.RFC3339),
+		Duration:   p.endTime.Sub(p.startTime).String(),
+	}
+
+	if res.Args == nil {
+		res.Args = []string{}
+	}
+
+	// Any error other than the command exiting is unexpected.
+	var exitErr *exec.ExitError
+	if p.err != nil && !errors.As(p.err, &exitErr) {
+		return nil, p.err
+	}
+
+	return &res, nil
+}
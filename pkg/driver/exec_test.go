@@ -0,0 +1,110 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/doc"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecRun(t *testing.T) {
+	e := NewExecDriver()
+
+	res, err := e.Run(&doc.Exec{
+		Command: "sh",
+		Args:    []string{"-c", `echo "$GREETING"; echo error >&2`},
+		Env:     map[string]string{"GREETING": "hello"},
+	})
+	require.NoError(t, err)
+
+	assert.True(t, res.Succeeded())
+	assert.Equal(t, "hello\n", res.Stdout)
+	assert.Equal(t, "error\n", res.Stderr)
+	assert.Equal(t, 0, res.ExitStatus)
+
+	res, err = e.Run(&doc.Exec{
+		Command: "sh",
+		Args:    []string{"-c", "pwd; exit 3"},
+		Dir:     "/",
+	})
+	require.NoError(t, err)
+
+	assert.False(t, res.Succeeded())
+	assert.Equal(t, "/\n", res.Stdout)
+	assert.Equal(t, 3, res.ExitStatus)
+
+	res, err = e.Run(&doc.Exec{
+		Command: "sleep",
+		Args:    []string{"10"},
+		Timeout: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+
+	assert.False(t, res.Succeeded())
+	assert.True(t, res.TimedOut)
+
+	_, err = e.Run(&doc.Exec{Command: "/no/such/command"})
+	assert.Error(t, err)
+}
+
+func TestExecRunTimeoutKillsChildren(t *testing.T) {
+	e := NewExecDriver()
+
+	// The shell forks sleep, which holds the shell's stdout
+	// open. The timeout has to kill both of them.
+	start := time.Now()
+	res, err := e.Run(&doc.Exec{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 60; echo done"},
+		Timeout: time.Millisecond * 100,
+	})
+	require.NoError(t, err)
+
+	assert.True(t, res.TimedOut)
+	assert.Less(t, int64(time.Since(start)), int64(10*time.Second))
+}
+
+func TestExecStart(t *testing.T) {
+	e := NewExecDriver()
+
+	p, err := e.Start(&doc.Exec{
+		Command: "sh",
+		Args:    []string{"-c", "sleep 60; echo done"},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "sh", p.Command())
+	assert.False(t, p.Exited())
+
+	res, err := p.Stop()
+	require.NoError(t, err)
+
+	assert.True(t, p.Exited())
+	assert.True(t, res.Stopped)
+	assert.True(t, res.Succeeded())
+	assert.Equal(t, "", res.Stdout)
+
+	// Commands that exit by themselves are not stopped.
+	p, err = e.Start(&doc.Exec{
+		Command: "sh",
+		Args:    []string{"-c", "exit 2"},
+	})
+	require.NoError(t, err)
+
+	for !p.Exited() {
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	res, err = p.Stop()
+	require.NoError(t, err)
+
+	assert.False(t, res.Stopped)
+	assert.False(t, res.Succeeded())
+	assert.Equal(t, 2, res.ExitStatus)
+
+	_, err = e.Start(&doc.Exec{Command: "/no/such/command"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package driver
+
+import (
+	"os/exec"
+)
+
+// setProcessGroup does nothing, since Windows doesn't have Unix
+// process groups.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kills the command. Children of the command
+// are not killed.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/jpeach/modden/pkg/filter"
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/utils"
+	"github.com/jpeach/modden/pkg/version"
 
 	v1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
@@ -265,6 +266,42 @@ func (k *KubeClient) RunIDFor(u *unstructured.Unstructured) (string, error) {
 	return "", nil
 }
 
+// PodsForRunID lists the pods that were created from a template that
+// was annotated with the given test run ID. Since we can't select on
+// annotations, this lists the pods that are labeled as managed, and
+// filters them by run ID.
+func (k *KubeClient) PodsForRunID(runID string) ([]v1.Pod, error) {
+	selector := labels.SelectorFromSet(labels.Set{
+		filter.LabelManagedBy: version.Progname,
+	}).String()
+
+	list, err := k.Client.CoreV1().Pods(metav1.NamespaceAll).List(
+		metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []v1.Pod
+
+	for _, p := range list.Items {
+		if p.GetAnnotations()[filter.LabelRunID] == runID {
+			pods = append(pods, p)
+		}
+	}
+
+	return pods, nil
+}
+
+// PodLogs returns the logs of the named container in the pod. If
+// previous is true, it returns the logs of the previous instance
+// of the container.
+func (k *KubeClient) PodLogs(pod *v1.Pod, container string, previous bool) ([]byte, error) {
+	return k.Client.CoreV1().Pods(pod.GetNamespace()).GetLogs(
+		pod.GetName(),
+		&v1.PodLogOptions{Container: container, Previous: previous},
+	).DoRaw()
+}
+
 // NewKubeClient returns a new set of Kubernetes client interfaces
 // that are configured to use the default Kubernetes context.
 func NewKubeClient() (*KubeClient, error) {
@@ -51,6 +51,14 @@ type ObjectDriver interface {
 	// DeleteAll operation.
 	Adopt(*unstructured.Unstructured) error
 
+	// IsAdopted returns whether the object with the given UID
+	// has been adopted by the driver.
+	IsAdopted(types.UID) bool
+
+	// AdoptedObjects returns copies of the latest version of
+	// each object that has been adopted by the driver.
+	AdoptedObjects() []*unstructured.Unstructured
+
 	// DeleteAll deletes all the objects that have been adopted by this driver.
 	DeleteAll() error
 
@@ -59,6 +67,12 @@ type ObjectDriver interface {
 	// watchers.
 	InformOn(gvr schema.GroupVersionResource) error
 
+	// InformOnNamespace establishes an informer for the given
+	// resource that only receives objects in the given namespace.
+	// Events received by this informer will be delivered to all
+	// watchers.
+	InformOnNamespace(gvr schema.GroupVersionResource, namespace string) error
+
 	// Watch registers an event handler to receive events from
 	// all the informers managed by the driver.
 	Watch(cache.ResourceEventHandler) func()
@@ -101,8 +115,9 @@ func NewObjectDriver(client *KubeClient) ObjectDriver {
 			Next: &MuxingResourceEventHandler{},
 		},
 
-		objectPool:   make(map[types.UID]*unstructured.Unstructured),
-		informerPool: make(map[schema.GroupVersionResource]informers.GenericInformer),
+		objectPool:         make(map[types.UID]*unstructured.Unstructured),
+		informerPool:       make(map[informerKey]informers.GenericInformer),
+		namespaceFactories: make(map[string]dynamicinformer.DynamicSharedInformerFactory),
 	}
 
 	return o
@@ -110,6 +125,13 @@ func NewObjectDriver(client *KubeClient) ObjectDriver {
 
 var _ ObjectDriver = &objectDriver{}
 
+// informerKey identifies an informer. Informers for all namespaces
+// have an empty namespace.
+type informerKey struct {
+	gvr       schema.GroupVersionResource
+	namespace string
+}
+
 type objectDriver struct {
 	kube *KubeClient
 
@@ -118,7 +140,8 @@ type objectDriver struct {
 
 	watcherLock LockingResourceEventHandler
 
-	informerPool map[schema.GroupVersionResource]informers.GenericInformer
+	informerPool       map[informerKey]informers.GenericInformer
+	namespaceFactories map[string]dynamicinformer.DynamicSharedInformerFactory
 
 	objectLock sync.Mutex
 	objectPool map[types.UID]*unstructured.Unstructured
@@ -141,7 +164,8 @@ func (o *objectDriver) Done() {
 
 	// There is no locking on the informer pool since driver
 	// methods must not be called concurrently.
-	o.informerPool = make(map[schema.GroupVersionResource]informers.GenericInformer)
+	o.informerPool = make(map[informerKey]informers.GenericInformer)
+	o.namespaceFactories = make(map[string]dynamicinformer.DynamicSharedInformerFactory)
 }
 
 func (o *objectDriver) Watch(e cache.ResourceEventHandler) func() {
@@ -159,12 +183,44 @@ func (o *objectDriver) Watch(e cache.ResourceEventHandler) func() {
 }
 
 func (o *objectDriver) InformOn(gvr schema.GroupVersionResource) error {
-	if _, ok := o.informerPool[gvr]; ok {
+	return o.inform(informerKey{gvr: gvr}, o.informerFactory)
+}
+
+func (o *objectDriver) InformOnNamespace(gvr schema.GroupVersionResource, namespace string) error {
+	if namespace == metav1.NamespaceAll {
+		return o.InformOn(gvr)
+	}
+
+	// An informer for all namespaces already covers this one.
+	if _, ok := o.informerPool[informerKey{gvr: gvr}]; ok {
+		return nil
+	}
+
+	factory, ok := o.namespaceFactories[namespace]
+	if !ok {
+		factory = dynamicinformer.NewFilteredDynamicSharedInformerFactory(
+			o.kube.Dynamic,
+			DefaultResyncPeriod,
+			namespace,
+			dynamicinformer.TweakListOptionsFunc(func(o *metav1.ListOptions) {}),
+		)
+
+		o.namespaceFactories[namespace] = factory
+	}
+
+	return o.inform(informerKey{gvr: gvr, namespace: namespace}, factory)
+}
+
+func (o *objectDriver) inform(
+	key informerKey,
+	factory dynamicinformer.DynamicSharedInformerFactory,
+) error {
+	if _, ok := o.informerPool[key]; ok {
 		return nil
 	}
 
 	// If we don't already have an informer for this resource, start one now.
-	genericInformer := o.informerFactory.ForResource(gvr)
+	genericInformer := factory.ForResource(key.gvr)
 	genericInformer.Informer().AddEventHandler(
 		&WrappingResourceEventHandlerFuncs{
 			Next: &o.watcherLock,
@@ -194,7 +250,7 @@ func (o *objectDriver) InformOn(gvr schema.GroupVersionResource) error {
 			},
 		})
 
-	o.informerPool[gvr] = genericInformer
+	o.informerPool[key] = genericInformer
 
 	go func() {
 		genericInformer.Informer().Run(o.informerStopper)
@@ -390,6 +446,26 @@ func (o *objectDriver) Adopt(obj *unstructured.Unstructured) error {
 	return nil
 }
 
+func (o *objectDriver) IsAdopted(uid types.UID) bool {
+	o.objectLock.Lock()
+	defer o.objectLock.Unlock()
+
+	_, ok := o.objectPool[uid]
+	return ok
+}
+
+func (o *objectDriver) AdoptedObjects() []*unstructured.Unstructured {
+	o.objectLock.Lock()
+	defer o.objectLock.Unlock()
+
+	objects := make([]*unstructured.Unstructured, 0, len(o.objectPool))
+	for _, u := range o.objectPool {
+		objects = append(objects, u.DeepCopy())
+	}
+
+	return objects
+}
+
 func (o *objectDriver) DeleteAll() error {
 	targets := make([]*unstructured.Unstructured, 0, len(o.objectPool))
 	var errs []error
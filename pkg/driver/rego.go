@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -16,13 +17,15 @@ import (
 	"github.com/open-policy-agent/opa/storage"
 	"github.com/open-policy-agent/opa/storage/inmem"
 	"github.com/open-policy-agent/opa/topdown"
+	"github.com/open-policy-agent/opa/util"
 	"sigs.k8s.io/yaml"
 )
 
 // RegoOpt is a convenience type alias.
 type RegoOpt = func(*rego.Rego)
 
-// RegoTracer is a tracer for check execution.
+// RegoTracer is a tracer for check execution. Trace events are
+// buffered until Write is called.
 type RegoTracer interface {
 	topdown.Tracer
 	Write()
@@ -34,12 +37,55 @@ type defaultTracer struct {
 }
 
 func (d *defaultTracer) Write() {
-	topdown.PrettyTrace(d.writer, *d.BufferTracer)
+	writeTraceWithLocation(d.writer, *d.BufferTracer)
+}
+
+// writeTraceWithLocation pretty prints the trace, prefixing each
+// event with its full source location. This is similar to
+// topdown.PrettyTraceWithLocation, except that the file name is not
+// truncated, since check locations refer to test documents.
+func writeTraceWithLocation(w io.Writer, trace []*topdown.Event) {
+	buf := bytes.Buffer{}
+	topdown.PrettyTrace(&buf, trace)
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	// PrettyTrace emits exactly one line per event. If that
+	// ever changes, we can't match up the locations.
+	if len(lines) != len(trace) {
+		must.Int(w.Write(buf.Bytes()))
+		return
+	}
+
+	width := 0
+	locations := make([]string, len(trace))
+
+	for i, e := range trace {
+		switch {
+		case e.Op == topdown.NoteOp:
+			locations[i] = "note"
+		case e.Location == nil:
+			locations[i] = ""
+		case e.Location.File == "":
+			locations[i] = fmt.Sprintf("query:%d", e.Location.Row)
+		default:
+			locations[i] = fmt.Sprintf("%s:%d", e.Location.File, e.Location.Row)
+		}
+
+		if len(locations[i]) > width {
+			width = len(locations[i])
+		}
+	}
+
+	for i := range lines {
+		must.Int(fmt.Fprintf(w, "%-*s %s\n", width, locations[i], lines[i]))
+	}
 }
 
 var _ RegoTracer = &defaultTracer{}
 
-// NewRegoTracer returns a new RegoTracer that traces to w.
+// NewRegoTracer returns a new RegoTracer that writes its buffered
+// trace to w.
 func NewRegoTracer(w io.Writer) RegoTracer {
 	return &defaultTracer{
 		BufferTracer: topdown.NewBufferTracer(),
@@ -52,7 +98,8 @@ type RegoDriver interface {
 	// Eval evaluates the given module and returns and check results.
 	Eval(*ast.Module, ...RegoOpt) ([]result.Result, error)
 
-	Trace(RegoTracer)
+	// Cover records the coverage of every evaluation.
+	Cover(*RegoCoverage)
 
 	// StoreItem stores the value at the given path in the Rego data document.
 	StoreItem(string, interface{}) error
@@ -62,6 +109,9 @@ type RegoDriver interface {
 
 	// RemovePath remove any object at the given path in the Rego data document.
 	RemovePath(where string) error
+
+	// ReadItem returns a copy of the value at the given path in the Rego data document.
+	ReadItem(where string) (interface{}, error)
 }
 
 // NewRegoDriver creates a new RegoDriver that evaluates checks
@@ -77,12 +127,12 @@ func NewRegoDriver() RegoDriver {
 var _ RegoDriver = &regoDriver{}
 
 type regoDriver struct {
-	store  storage.Store
-	tracer RegoTracer
+	store    storage.Store
+	coverage *RegoCoverage
 }
 
-func (r *regoDriver) Trace(tracer RegoTracer) {
-	r.tracer = tracer
+func (r *regoDriver) Cover(c *RegoCoverage) {
+	r.coverage = c
 }
 
 // StoreItem stores the value at the given Rego store path.
@@ -162,6 +212,26 @@ func (r *regoDriver) RemovePath(where string) error {
 	return nil
 }
 
+// ReadItem returns a copy of the value at the given path in the Rego
+// data document. The value is round-tripped through JSON so that the
+// caller can freely modify it.
+func (r *regoDriver) ReadItem(where string) (interface{}, error) {
+	ctx := context.Background()
+	txn := storage.NewTransactionOrDie(ctx, r.store)
+	defer r.store.Abort(ctx, txn)
+
+	val, err := r.store.Read(ctx, txn, storage.MustParsePath(where))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := util.RoundTrip(&val); err != nil {
+		return nil, err
+	}
+
+	return val, nil
+}
+
 // Eval evaluates checks in the given module.
 func (r *regoDriver) Eval(m *ast.Module, opts ...RegoOpt) ([]result.Result, error) {
 	// Find the unique set of assertion rules to query.
@@ -191,17 +261,13 @@ func (r *regoDriver) Eval(m *ast.Module, opts ...RegoOpt) ([]result.Result, erro
 
 		options = append(options, opts...)
 
-		if r.tracer != nil {
-			options = append(options, rego.Tracer(r.tracer))
+		if r.coverage != nil {
+			options = append(options, rego.Tracer(r.coverage))
 		}
 
 		regoObj := rego.New(options...)
 		resultSet, err := regoObj.Eval(context.Background())
 
-		if r.tracer != nil {
-			r.tracer.Write()
-		}
-
 		// If this was a builtin error, we can return it as a
 		// result. Builtins that fail are typically those that
 		// access external resources (e.g. HTTP), in which case
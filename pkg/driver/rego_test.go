@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"bytes"
 	"context"
 	"testing"
 
@@ -278,3 +279,48 @@ func TestStoreRemoveItem(t *testing.T) {
 
 	assert.True(t, storage.IsNotFound(r.RemovePath("/no/such/path")))
 }
+
+func TestStoreReadItem(t *testing.T) {
+	r := NewRegoDriver()
+
+	storedValue := map[string]interface{}{
+		"first":  "one",
+		"second": []interface{}{"two"},
+	}
+
+	assert.NoError(t, r.StorePath("/test/path"))
+	assert.NoError(t, r.StoreItem("/test/path/item", storedValue))
+
+	val, err := r.ReadItem("/test/path/item")
+	require.NoError(t, err)
+	assert.Equal(t, storedValue, val)
+
+	// Modifying the copy must not change the stored value.
+	val.(map[string]interface{})["first"] = "changed"
+
+	val, err = r.ReadItem("/test/path/item")
+	require.NoError(t, err)
+	assert.Equal(t, storedValue, val)
+
+	_, err = r.ReadItem("/no/such/path")
+	assert.True(t, storage.IsNotFound(err), "error is %s", err)
+}
+
+func TestTraceLocation(t *testing.T) {
+	r := NewRegoDriver()
+	buf := bytes.Buffer{}
+	tracer := NewRegoTracer(&buf)
+
+	m, compiler := parse(t,
+		`package test
+
+error[msg] { msg = "this is the error"}
+`)
+
+	_, err := r.Eval(m, compiler, rego.Tracer(tracer))
+	require.NoError(t, err)
+
+	tracer.Write()
+
+	assert.Contains(t, buf.String(), "test:3 ")
+}
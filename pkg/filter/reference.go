@@ -0,0 +1,173 @@
+package filter
+
+import (
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	// ReferenceAnyKey is a ReferencePath element that matches
+	// every value in a mapping node.
+	ReferenceAnyKey = "*"
+
+	// ReferenceAnyItem is a ReferencePath element that matches
+	// every item in a sequence node.
+	ReferenceAnyItem = "[]"
+)
+
+// ReferencePath is the path to a field that may contain a
+// reference to the name of another object. Path elements are
+// field names, or ReferenceAnyKey or ReferenceAnyItem wildcards.
+type ReferencePath []string
+
+// ParseReferencePath parses a reference path written as field names
+// separated by dots, e.g. "spec.routes.[].services.[].name".
+func ParseReferencePath(path string) ReferencePath {
+	return ReferencePath(strings.Split(path, "."))
+}
+
+// ReferenceTable maps an object kind to the paths of the fields
+// that may refer to other objects by name. The ReferenceAnyKey
+// kind holds paths that apply to objects of every kind.
+type ReferenceTable map[string][]ReferencePath
+
+// Merge returns a new ReferenceTable that contains the paths of
+// both t and other.
+func (t ReferenceTable) Merge(other ReferenceTable) ReferenceTable {
+	merged := ReferenceTable{}
+
+	for _, table := range []ReferenceTable{t, other} {
+		for kind, paths := range table {
+			merged[kind] = append(merged[kind], paths...)
+		}
+	}
+
+	return merged
+}
+
+// podTemplateReferences are the reference paths in objects that
+// contain a pod template.
+var podTemplateReferences = []ReferencePath{
+	{"spec", "selector", "matchLabels", ReferenceAnyKey},
+	{"spec", "template", "metadata", "labels", ReferenceAnyKey},
+	{"spec", "template", "spec", "volumes", ReferenceAnyItem, "configMap", "name"},
+	{"spec", "template", "spec", "volumes", ReferenceAnyItem, "secret", "secretName"},
+	{"spec", "template", "spec", "containers", ReferenceAnyItem, "envFrom", ReferenceAnyItem, "configMapRef", "name"},
+	{"spec", "template", "spec", "containers", ReferenceAnyItem, "envFrom", ReferenceAnyItem, "secretRef", "name"},
+}
+
+// DefaultReferenceTable is the ReferenceTable for well-known
+// Kubernetes and Contour kinds.
+var DefaultReferenceTable = ReferenceTable{
+	ReferenceAnyKey: {
+		{"metadata", "labels", ReferenceAnyKey},
+	},
+	"Deployment":  podTemplateReferences,
+	"DaemonSet":   podTemplateReferences,
+	"StatefulSet": podTemplateReferences,
+	"ReplicaSet":  podTemplateReferences,
+	"Job":         podTemplateReferences,
+	"Pod": {
+		{"spec", "volumes", ReferenceAnyItem, "configMap", "name"},
+		{"spec", "volumes", ReferenceAnyItem, "secret", "secretName"},
+		{"spec", "containers", ReferenceAnyItem, "envFrom", ReferenceAnyItem, "configMapRef", "name"},
+		{"spec", "containers", ReferenceAnyItem, "envFrom", ReferenceAnyItem, "secretRef", "name"},
+	},
+	"Service": {
+		{"spec", "selector", ReferenceAnyKey},
+	},
+	"Ingress": {
+		// extensions/v1beta1 and networking.k8s.io/v1beta1.
+		{"spec", "backend", "serviceName"},
+		{"spec", "rules", ReferenceAnyItem, "http", "paths", ReferenceAnyItem, "backend", "serviceName"},
+		// networking.k8s.io/v1.
+		{"spec", "defaultBackend", "service", "name"},
+		{"spec", "rules", ReferenceAnyItem, "http", "paths", ReferenceAnyItem, "backend", "service", "name"},
+		{"spec", "tls", ReferenceAnyItem, "secretName"},
+	},
+	"HTTPProxy": {
+		{"spec", "routes", ReferenceAnyItem, "services", ReferenceAnyItem, "name"},
+		{"spec", "tcpproxy", "services", ReferenceAnyItem, "name"},
+		{"spec", "includes", ReferenceAnyItem, "name"},
+		{"spec", "virtualhost", "tls", "secretName"},
+	},
+}
+
+// RenameReferences is a filter that rewrites the fields of a
+// Kubernetes object that refer to renamed objects. Names maps the
+// original name of each renamed object to its new name, and only
+// scalar fields whose value is exactly one of the original names are
+// rewritten. Like Rename, alias nodes are not rewritten.
+type RenameReferences struct {
+	// Names maps original object names to new names.
+	Names map[string]string
+	// Table is the table of reference paths. If it is nil,
+	// DefaultReferenceTable is used.
+	Table ReferenceTable
+}
+
+// Filter applies the reference rename and returns rn.
+func (r RenameReferences) Filter(rn *yaml.RNode) (*yaml.RNode, error) {
+	if len(r.Names) == 0 {
+		return rn, nil
+	}
+
+	table := r.Table
+	if table == nil {
+		table = DefaultReferenceTable
+	}
+
+	meta, err := rn.GetMeta()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := append(append([]ReferencePath{}, table[ReferenceAnyKey]...), table[meta.Kind]...)
+
+	for _, p := range paths {
+		walkReferencePath(rn.YNode(), p, func(n *yaml.Node) {
+			if n.Kind != yaml.ScalarNode {
+				return
+			}
+
+			if to, ok := r.Names[n.Value]; ok && to != "" {
+				n.SetString(to)
+			}
+		})
+	}
+
+	return rn, nil
+}
+
+// walkReferencePath calls visit for each node in the tree that
+// matches the path.
+func walkReferencePath(node *yaml.Node, path ReferencePath, visit func(*yaml.Node)) {
+	if node == nil {
+		return
+	}
+
+	if len(path) == 0 {
+		visit(node)
+		return
+	}
+
+	switch elem := path[0]; {
+	case elem == ReferenceAnyItem:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+
+		for _, n := range node.Content {
+			walkReferencePath(n, path[1:], visit)
+		}
+
+	case node.Kind == yaml.MappingNode:
+		// Mapping nodes hold alternating keys and values.
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if elem == ReferenceAnyKey || node.Content[i].Value == elem {
+				walkReferencePath(node.Content[i+1], path[1:], visit)
+			}
+		}
+	}
+}
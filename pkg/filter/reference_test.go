@@ -0,0 +1,209 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestRenameReferences(t *testing.T) {
+	deployment := yaml.MustParse(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  labels:
+    app: httpbin
+    tier: web
+spec:
+  selector:
+    matchLabels:
+      app: httpbin
+  template:
+    metadata:
+      labels:
+        app: httpbin
+    spec:
+      containers:
+      - name: httpbin
+        envFrom:
+        - configMapRef:
+            name: httpbin
+      volumes:
+      - name: config
+        configMap:
+          name: httpbin
+      - name: certs
+        secret:
+          secretName: other
+`)
+
+	_, err := deployment.Pipe(RenameReferences{Names: map[string]string{"httpbin": "echo"}})
+	require.NoError(t, err)
+
+	wanted := yaml.MustParse(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  labels:
+    app: echo
+    tier: web
+spec:
+  selector:
+    matchLabels:
+      app: echo
+  template:
+    metadata:
+      labels:
+        app: echo
+    spec:
+      containers:
+      - name: httpbin
+        envFrom:
+        - configMapRef:
+            name: echo
+      volumes:
+      - name: config
+        configMap:
+          name: echo
+      - name: certs
+        secret:
+          secretName: other
+`)
+
+	// Note that metadata.name and the container name are not references.
+	assert.Equal(t, wanted.MustString(), deployment.MustString())
+
+	proxy := yaml.MustParse(`
+apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: httpbin
+spec:
+  routes:
+  - services:
+    - name: httpbin
+      port: 80
+    - name: httpbin-v2
+      port: 80
+`)
+
+	_, err = proxy.Pipe(RenameReferences{Names: map[string]string{"httpbin": "echo"}})
+	require.NoError(t, err)
+
+	assert.Equal(t, `apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: httpbin
+spec:
+  routes:
+  - services:
+    - name: echo
+      port: 80
+    - name: httpbin-v2
+      port: 80
+`, proxy.MustString())
+
+	// A custom table only rewrites its own paths.
+	service := yaml.MustParse(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  labels:
+    app: httpbin
+spec:
+  selector:
+    app: httpbin
+`)
+
+	_, err = service.Pipe(RenameReferences{
+		Names: map[string]string{"httpbin": "echo"},
+		Table: ReferenceTable{
+			"Service": {{"spec", "selector", ReferenceAnyKey}},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, `apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+  labels:
+    app: httpbin
+spec:
+  selector:
+    app: echo
+`, service.MustString())
+
+	// References to every renamed object are rewritten, and
+	// networking.k8s.io/v1 Ingress backends are references.
+	ingress := yaml.MustParse(`
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: httpbin
+spec:
+  defaultBackend:
+    service:
+      name: httpbin
+  rules:
+  - http:
+      paths:
+      - backend:
+          service:
+            name: httpbin-admin
+  tls:
+  - secretName: httpbin-tls
+`)
+
+	_, err = ingress.Pipe(RenameReferences{Names: map[string]string{
+		"httpbin":       "echo",
+		"httpbin-admin": "echo-admin",
+		"httpbin-tls":   "echo-tls",
+	}})
+	require.NoError(t, err)
+
+	assert.Equal(t, `apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: httpbin
+spec:
+  defaultBackend:
+    service:
+      name: echo
+  rules:
+  - http:
+      paths:
+      - backend:
+          service:
+            name: echo-admin
+  tls:
+  - secretName: echo-tls
+`, ingress.MustString())
+}
+
+func TestReferenceTableMerge(t *testing.T) {
+	table := ReferenceTable{"Service": {{"spec", "selector", ReferenceAnyKey}}}
+
+	merged := table.Merge(ReferenceTable{
+		"Service": {ParseReferencePath("spec.externalName")},
+		"Gateway": {ParseReferencePath("spec.listeners.[].tls.certificateRef.name")},
+	})
+
+	assert.Equal(t, ReferenceTable{
+		"Service": {
+			{"spec", "selector", ReferenceAnyKey},
+			{"spec", "externalName"},
+		},
+		"Gateway": {
+			{"spec", "listeners", ReferenceAnyItem, "tls", "certificateRef", "name"},
+		},
+	}, merged)
+
+	// The original table is unchanged.
+	assert.Len(t, table["Service"], 1)
+}
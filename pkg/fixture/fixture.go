@@ -2,16 +2,27 @@ package fixture
 
 import (
 	"fmt"
+	"path/filepath"
+	"strings"
 
 	"github.com/jpeach/modden/pkg/doc"
 	"github.com/jpeach/modden/pkg/filter"
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/utils"
+
+	jsonpatch "github.com/evanphx/json-patch"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
 	"sigs.k8s.io/kustomize/kyaml/yaml"
 	sigyaml "sigs.k8s.io/yaml"
 )
 
+// GroupLabel is the label that names the group that a fixture
+// belongs to. Fixtures without this label belong to the group named
+// after the file that they were loaded from.
+const GroupLabel = "modden/fixture-group"
+
 // Fixture captures a single Kubernetes object that can be used as
 // a test fixture. The fixture is stored as a YAML string so that
 // is can be succinctly copied and losslessly rewritten.
@@ -55,14 +66,147 @@ func (f Fixture) Rename(newName string) (Fixture, error) {
 	return Fixture(resource.MustString()), nil
 }
 
+// RenameReferences renames the fixture in the same way as Rename,
+// and also rewrites the fields that the reference table lists as
+// referring to the fixture's original name. This updates label
+// selectors, pod template labels and other well-known references.
+// If table is nil, filter.DefaultReferenceTable is used.
+func (f Fixture) RenameReferences(newName string, table filter.ReferenceTable) (Fixture, error) {
+	_, name := utils.SplitObjectName(newName)
+
+	return f.renameReferences(newName, map[string]string{
+		f.AsUnstructured().GetName(): name,
+	}, table)
+}
+
+// renameReferences renames the fixture and rewrites its references
+// to any of the renamed objects in names, which maps original names
+// to new names.
+func (f Fixture) renameReferences(newName string, names map[string]string, table filter.ReferenceTable) (Fixture, error) {
+	renamed, err := f.Rename(newName)
+	if err != nil {
+		return nil, err
+	}
+
+	resource := renamed.AsNode()
+
+	_, err = resource.Pipe(&filter.RenameReferences{
+		Names: names,
+		Table: table,
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename object references: %w", err)
+	}
+
+	return Fixture(resource.MustString()), nil
+}
+
+// RenameGroup gives every member of a fixture group the same new
+// name, and rewrites the references between the members so that the
+// renamed group continues to refer to each other. A reference to any
+// member's original name is rewritten, so a Deployment that refers to
+// a ConfigMap in the group by its name refers to the renamed ConfigMap.
+// If table is nil, filter.DefaultReferenceTable is used.
+func RenameGroup(members []Fixture, newName string, table filter.ReferenceTable) ([]Fixture, error) {
+	_, name := utils.SplitObjectName(newName)
+
+	names := map[string]string{}
+	for _, m := range members {
+		names[m.AsUnstructured().GetName()] = name
+	}
+
+	renamed := make([]Fixture, 0, len(members))
+	keys := map[Key]bool{}
+
+	for _, m := range members {
+		r, err := m.renameReferences(newName, names, table)
+		if err != nil {
+			return nil, err
+		}
+
+		// Members of the same type would end up with the same
+		// name, and overwrite each other.
+		key := KeyFor(r.AsUnstructured())
+		if keys[key] {
+			return nil, fmt.Errorf("renaming fixture group to %q duplicates %s", newName, key)
+		}
+
+		keys[key] = true
+		renamed = append(renamed, r)
+	}
+
+	return renamed, nil
+}
+
+// Merge applies the fields of the overlay object onto the fixture.
+// The apiVersion, kind, name and namespace of the overlay are ignored,
+// since these are used to match the fixture. Built-in Kubernetes
+// types are merged with strategic merge semantics, and all other
+// types are merged with JSON merge semantics. If there are no fields
+// to merge, the fixture is returned unchanged.
+func (f Fixture) Merge(overlay *unstructured.Unstructured) (Fixture, error) {
+	overlay = overlay.DeepCopy()
+
+	unstructured.RemoveNestedField(overlay.Object, "apiVersion")
+	unstructured.RemoveNestedField(overlay.Object, "kind")
+	unstructured.RemoveNestedField(overlay.Object, "metadata", "name")
+	unstructured.RemoveNestedField(overlay.Object, "metadata", "namespace")
+
+	if meta, ok := overlay.Object["metadata"].(map[string]interface{}); ok && len(meta) == 0 {
+		unstructured.RemoveNestedField(overlay.Object, "metadata")
+	}
+
+	if len(overlay.Object) == 0 {
+		return f, nil
+	}
+
+	base, err := sigyaml.YAMLToJSON(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert fixture to JSON: %w", err)
+	}
+
+	patch, err := overlay.MarshalJSON()
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert overlay to JSON: %w", err)
+	}
+
+	var merged []byte
+
+	// Emulate kubectl by using the scheme check to test whether
+	// this object is builtin and supports strategic merge.
+	if obj, err := scheme.Scheme.New(f.AsUnstructured().GroupVersionKind()); err == nil {
+		merged, err = strategicpatch.StrategicMergePatch(base, patch, obj)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge fixture: %w", err)
+		}
+	} else {
+		merged, err = jsonpatch.MergePatch(base, patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge fixture: %w", err)
+		}
+	}
+
+	result, err := sigyaml.JSONToYAML(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert fixture to YAML: %w", err)
+	}
+
+	return Fixture(result), nil
+}
+
 // AddFromFile parses all the YAML objects from the given file and
-// stores them in the default fixture set.
-func AddFromFile(filePath string) error {
+// inserts them into the fixture set. Each object is added to the group
+// named by its GroupLabel, or to the group named by the file basename
+// (without the extension), e.g. "httpbin" for "fixtures/httpbin.yaml".
+func AddFromFile(set FixtureSet, filePath string) error {
 	d, err := doc.ReadFile(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to read %q`: %w", filePath, err)
 	}
 
+	fileGroup := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+
 	for i, p := range d.Parts {
 		ftype, err := p.Decode()
 		if err != nil {
@@ -70,13 +214,37 @@ func AddFromFile(filePath string) error {
 				"failed to parse document fragment %d: %w", i, err)
 		}
 
-		if ftype == doc.FragmentTypeObject {
-			Set.Insert(
-				KeyFor(p.Object()),
-				Fixture(utils.CopyBytes(p.Bytes)),
-			)
+		// Fixture group references don't have a kind, and
+		// can't themselves be fixtures.
+		if ftype == doc.FragmentTypeObject && p.Object().GetKind() != "" {
+			group := fileGroup
+			if label, ok := p.Object().GetLabels()[GroupLabel]; ok {
+				group = label
+			}
+
+			if err := set.Insert(Entry{
+				Key:     KeyFor(p.Object()),
+				Fixture: Fixture(utils.CopyBytes(p.Bytes)),
+				Source:  filePath,
+				Groups:  []string{group},
+			}); err != nil {
+				return fmt.Errorf("fragment at lines %d-%d: %w",
+					p.Location.Start, p.Location.End, err)
+			}
 		}
 	}
 
 	return nil
 }
+
+// AddFromPath adds fixtures from all the files in the given path,
+// which may be either a file or a directory.
+func AddFromPath(set FixtureSet, path string) error {
+	return utils.WalkFiles(path, func(filePath string) error {
+		if err := AddFromFile(set, filePath); err != nil {
+			return fmt.Errorf("failed to parse %q`: %w", filePath, err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,212 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+func parse(t *testing.T, data string) *unstructured.Unstructured {
+	t.Helper()
+
+	u := &unstructured.Unstructured{}
+	require.NoError(t, yaml.Unmarshal([]byte(data), &u.Object))
+	return u
+}
+
+func TestMergeStrategic(t *testing.T) {
+	f := Fixture(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  labels:
+    app: httpbin
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: httpbin
+        image: httpbin:v1
+        ports:
+        - containerPort: 80
+`)
+
+	merged, err := f.Merge(parse(t, `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  labels:
+    tier: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: httpbin
+        image: httpbin:v2
+`))
+	require.NoError(t, err)
+
+	u := merged.AsUnstructured()
+	assert.Equal(t, "httpbin", u.GetName())
+	assert.Equal(t, map[string]string{"app": "httpbin", "tier": "web"}, u.GetLabels())
+
+	replicas, _, _ := unstructured.NestedInt64(u.Object, "spec", "replicas")
+	assert.Equal(t, int64(3), replicas)
+
+	// Containers are merged by name, so the ports are retained.
+	containers, _, _ := unstructured.NestedSlice(u.Object, "spec", "template", "spec", "containers")
+	require.Len(t, containers, 1)
+	assert.Equal(t, "httpbin:v2", containers[0].(map[string]interface{})["image"])
+	assert.NotNil(t, containers[0].(map[string]interface{})["ports"])
+}
+
+func TestMergeJSON(t *testing.T) {
+	f := Fixture(`
+apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: httpbin
+spec:
+  virtualhost:
+    fqdn: httpbin.projectcontour.io
+  routes:
+  - services:
+    - name: httpbin
+      port: 80
+`)
+
+	merged, err := f.Merge(parse(t, `
+apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: something-else
+  namespace: elsewhere
+spec:
+  virtualhost:
+    fqdn: echo.projectcontour.io
+`))
+	require.NoError(t, err)
+
+	u := merged.AsUnstructured()
+	assert.Equal(t, "httpbin", u.GetName())
+	assert.Equal(t, "", u.GetNamespace())
+
+	fqdn, _, _ := unstructured.NestedString(u.Object, "spec", "virtualhost", "fqdn")
+	assert.Equal(t, "echo.projectcontour.io", fqdn)
+
+	routes, _, _ := unstructured.NestedSlice(u.Object, "spec", "routes")
+	assert.Len(t, routes, 1)
+}
+
+func TestMergeEmpty(t *testing.T) {
+	f := Fixture(`# comments are preserved
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+`)
+
+	merged, err := f.Merge(parse(t, `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: test
+`))
+	require.NoError(t, err)
+	assert.Equal(t, f, merged)
+}
+
+func TestAddFromFileGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fixture")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "httpbin.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  labels:
+    modden/fixture-group: config
+`), 0644))
+
+	s := NewSet()
+	require.NoError(t, AddFromFile(s, path))
+
+	kinds := func(members []Fixture) []string {
+		var k []string
+		for _, m := range members {
+			k = append(k, m.AsUnstructured().GetKind())
+		}
+		return k
+	}
+
+	// Objects are grouped by file name, unless they have a group label.
+	assert.Equal(t, []string{"Deployment", "Service"}, kinds(s.Group("httpbin")))
+	assert.Equal(t, []string{"ConfigMap"}, kinds(s.Group("config")))
+	assert.Empty(t, s.Group("missing"))
+}
+
+func TestRenameGroup(t *testing.T) {
+	members := []Fixture{
+		Fixture(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: httpbin
+spec:
+  selector:
+    app: httpbin
+`),
+		Fixture(`
+apiVersion: projectcontour.io/v1
+kind: HTTPProxy
+metadata:
+  name: httpbin-proxy
+spec:
+  routes:
+  - services:
+    - name: httpbin
+`),
+	}
+
+	renamed, err := RenameGroup(members, "test/echo", nil)
+	require.NoError(t, err)
+	require.Len(t, renamed, 2)
+
+	svc := renamed[0].AsUnstructured()
+	assert.Equal(t, "echo", svc.GetName())
+	assert.Equal(t, "test", svc.GetNamespace())
+
+	proxy := renamed[1].AsUnstructured()
+	assert.Equal(t, "echo", proxy.GetName())
+
+	routes, _, _ := unstructured.NestedSlice(proxy.Object, "spec", "routes")
+	require.Len(t, routes, 1)
+	services := routes[0].(map[string]interface{})["services"].([]interface{})
+	assert.Equal(t, "echo", services[0].(map[string]interface{})["name"])
+
+	// Members with the same kind can't be renamed to the same name.
+	_, err = RenameGroup([]Fixture{members[0], members[0]}, "echo", nil)
+	assert.Error(t, err)
+}
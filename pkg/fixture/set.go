@@ -1,49 +1,152 @@
 package fixture
 
 import (
+	"bytes"
+	"fmt"
+	"sort"
 	"sync"
 
+	"github.com/jpeach/modden/pkg/utils"
+
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 // FixtureSet is a collection of fixture objects.
 // nolint(golint)
 type FixtureSet interface {
-	Insert(Key, Fixture)
+	// Insert adds a fixture entry, making it a member of each
+	// of the entry's groups. It is an error to insert a different
+	// fixture with a key that is already present in the set.
+	Insert(Entry) error
+
+	// Match returns the fixture that matches the given object,
+	// or nil if there is no match.
 	Match(u *unstructured.Unstructured) Fixture
+
+	// Group returns the members of the named fixture group,
+	// in the order that they were inserted.
+	Group(name string) []Fixture
+
+	// Entries returns all the entries in the set, in the order
+	// that they were inserted.
+	Entries() []Entry
 }
 
 // Key is the indexing fixture set key.
 type Key struct {
-	apiVersion string
-	kind       string
-	name       string
-	namespace  string
+	APIVersion string
+	Kind       string
+	Name       string
+	Namespace  string
+}
+
+// String formats the key as "apiVersion:kind 'namespace/name'".
+func (k Key) String() string {
+	if k.Namespace == "" {
+		return fmt.Sprintf("%s:%s '%s'", k.APIVersion, k.Kind, k.Name)
+	}
+
+	return fmt.Sprintf("%s:%s '%s/%s'", k.APIVersion, k.Kind, k.Namespace, k.Name)
 }
 
 // KeyFor returns the key for indexing the given object.
 func KeyFor(u *unstructured.Unstructured) Key {
 	return Key{
-		apiVersion: u.GetAPIVersion(),
-		kind:       u.GetKind(),
-		name:       u.GetName(),
-		namespace:  u.GetNamespace(),
+		APIVersion: u.GetAPIVersion(),
+		Kind:       u.GetKind(),
+		Name:       u.GetName(),
+		Namespace:  u.GetNamespace(),
+	}
+}
+
+// Entry is a fixture in a FixtureSet.
+type Entry struct {
+	Key     Key
+	Fixture Fixture
+	// Source is where the fixture was loaded from, usually
+	// a file path. It may be empty.
+	Source string
+	// Groups are the fixture groups that the fixture belongs to.
+	Groups []string
+}
+
+// NewEntry returns an Entry for the fixture.
+func NewEntry(f Fixture, source string, groups ...string) Entry {
+	return Entry{
+		Key:     KeyFor(f.AsUnstructured()),
+		Fixture: f,
+		Source:  source,
+		Groups:  groups,
+	}
+}
+
+// ConflictErr is returned when a fixture is inserted with a key
+// that already has a different fixture.
+type ConflictErr struct {
+	Key Key
+	// Sources are the sources of the existing fixture and of
+	// the conflicting fixture.
+	Sources []string
+}
+
+func (c *ConflictErr) Error() string {
+	if len(c.Sources) == 2 && c.Sources[0] != "" && c.Sources[1] != "" {
+		return fmt.Sprintf("duplicate fixture for %s in %s and %s",
+			c.Key, c.Sources[0], c.Sources[1])
+	}
+
+	return fmt.Sprintf("duplicate fixture for %s", c.Key)
+}
+
+// NewSet returns a new, empty FixtureSet.
+func NewSet() FixtureSet {
+	return &defaultFixtureSet{
+		fixtures: map[Key]*Entry{},
+		groups:   map[string][]Key{},
 	}
 }
 
 type defaultFixtureSet struct {
 	lock     sync.Mutex
-	fixtures map[Key]Fixture
+	keys     []Key
+	fixtures map[Key]*Entry
+	groups   map[string][]Key
 }
 
 var _ FixtureSet = &defaultFixtureSet{}
 
-// Insert a fixture with the given key.
-func (s *defaultFixtureSet) Insert(k Key, f Fixture) {
+// Insert a fixture entry.
+func (s *defaultFixtureSet) Insert(e Entry) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	s.fixtures[k] = f
+	existing, ok := s.fixtures[e.Key]
+	switch {
+	case !ok:
+		s.keys = append(s.keys, e.Key)
+		s.fixtures[e.Key] = &Entry{
+			Key:     e.Key,
+			Fixture: e.Fixture,
+			Source:  e.Source,
+		}
+		existing = s.fixtures[e.Key]
+	case !bytes.Equal(existing.Fixture, e.Fixture):
+		// Loading the same fixture twice is harmless, but two
+		// different fixtures with the same key is ambiguous.
+		return &ConflictErr{
+			Key:     e.Key,
+			Sources: []string{existing.Source, e.Source},
+		}
+	}
+
+	for _, g := range e.Groups {
+		if !utils.ContainsString(existing.Groups, g) {
+			existing.Groups = append(existing.Groups, g)
+			s.groups[g] = append(s.groups[g], e.Key)
+		}
+	}
+
+	return nil
 }
 
 // Match the given object to an existing Fixture.
@@ -52,10 +155,143 @@ func (s *defaultFixtureSet) Match(u *unstructured.Unstructured) Fixture {
 	defer s.lock.Unlock()
 
 	// Assume that the caller will not modify the result.
-	return s.fixtures[KeyFor(u)]
+	if e, ok := s.fixtures[KeyFor(u)]; ok {
+		return e.Fixture
+	}
+
+	return nil
+}
+
+// Group returns the members of the named fixture group.
+func (s *defaultFixtureSet) Group(name string) []Fixture {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var members []Fixture
+	for _, k := range s.groups[name] {
+		members = append(members, s.fixtures[k].Fixture)
+	}
+
+	return members
 }
 
-// Set is the default FixtureSet.
-var Set = &defaultFixtureSet{
-	fixtures: map[Key]Fixture{},
+// Entries returns all the entries in the set.
+func (s *defaultFixtureSet) Entries() []Entry {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	entries := make([]Entry, 0, len(s.keys))
+	for _, k := range s.keys {
+		e := *s.fixtures[k]
+		e.Groups = utils.CopyStrings(e.Groups)
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// NewLayeredSet returns a FixtureSet that is layered over the base
+// set. Fixtures are inserted into the top layer, and fixtures in the
+// top layer take precedence over fixtures in the base set with the
+// same key. The base set is not modified.
+func NewLayeredSet(base FixtureSet) FixtureSet {
+	return &layeredFixtureSet{
+		top:  NewSet(),
+		base: base,
+	}
+}
+
+type layeredFixtureSet struct {
+	top  FixtureSet
+	base FixtureSet
+}
+
+var _ FixtureSet = &layeredFixtureSet{}
+
+// Insert a fixture into the top layer.
+func (l *layeredFixtureSet) Insert(e Entry) error {
+	return l.top.Insert(e)
+}
+
+// Match the given object, preferring the top layer.
+func (l *layeredFixtureSet) Match(u *unstructured.Unstructured) Fixture {
+	if match := l.top.Match(u); match != nil {
+		return match
+	}
+
+	if l.base == nil {
+		return nil
+	}
+
+	return l.base.Match(u)
+}
+
+// Group returns the named group from the top layer or, if the top
+// layer doesn't have the group, from the base set. Groups are not
+// merged across layers, so that a document can replace a group.
+func (l *layeredFixtureSet) Group(name string) []Fixture {
+	if members := l.top.Group(name); len(members) > 0 {
+		return members
+	}
+
+	if l.base == nil {
+		return nil
+	}
+
+	return l.base.Group(name)
+}
+
+// Entries returns the entries in the top layer, followed by the
+// entries in the base set that are not overridden by the top layer.
+func (l *layeredFixtureSet) Entries() []Entry {
+	entries := l.top.Entries()
+	if l.base == nil {
+		return entries
+	}
+
+	top := map[Key]bool{}
+	for _, e := range entries {
+		top[e.Key] = true
+	}
+
+	for _, e := range l.base.Entries() {
+		if !top[e.Key] {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Closest returns up to n keys from the set that are the most similar
+// to the wanted key, ordered from the most to the least similar. Keys
+// that are too different to plausibly be a typo are not returned.
+func Closest(s FixtureSet, wanted Key, n int) []Key {
+	type candidate struct {
+		key      Key
+		distance int
+	}
+
+	var candidates []candidate
+
+	// Allow about a third of the wanted key to be different.
+	limit := len(wanted.String())/3 + 1
+
+	for _, e := range s.Entries() {
+		d := utils.EditDistance(wanted.String(), e.Key.String())
+		if d <= limit {
+			candidates = append(candidates, candidate{key: e.Key, distance: d})
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].distance < candidates[j].distance
+	})
+
+	var keys []Key
+	for i := 0; i < len(candidates) && i < n; i++ {
+		keys = append(keys, candidates[i].key)
+	}
+
+	return keys
 }
@@ -0,0 +1,94 @@
+package fixture
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetInsert(t *testing.T) {
+	f := Fixture("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n")
+	k := KeyFor(f.AsUnstructured())
+
+	s := NewSet()
+	require.NoError(t, s.Insert(NewEntry(f, "first.yaml")))
+	assert.Equal(t, f, s.Match(f.AsUnstructured()))
+
+	// Inserting the same fixture again is not a conflict.
+	assert.NoError(t, s.Insert(NewEntry(f, "first.yaml")))
+
+	// Inserting a different fixture is a conflict.
+	err := s.Insert(NewEntry(Fixture(string(f)+"data:\n  key: val\n"), "second.yaml"))
+	assert.Equal(t, &ConflictErr{Key: k, Sources: []string{"first.yaml", "second.yaml"}}, err)
+	assert.EqualError(t, err, "duplicate fixture for v1:ConfigMap 'config' in first.yaml and second.yaml")
+	assert.Equal(t, f, s.Match(f.AsUnstructured()))
+}
+
+func TestLayeredSet(t *testing.T) {
+	base := NewSet()
+	baseConfig := Fixture("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n")
+	baseSecret := Fixture("apiVersion: v1\nkind: Secret\nmetadata:\n  name: secret\n  namespace: test\n")
+
+	require.NoError(t, base.Insert(NewEntry(baseConfig, "")))
+	require.NoError(t, base.Insert(NewEntry(baseSecret, "")))
+
+	layer := NewLayeredSet(base)
+	topConfig := Fixture(string(baseConfig) + "data:\n  layer: top\n")
+
+	// The top layer can override the base set.
+	require.NoError(t, layer.Insert(NewEntry(topConfig, "")))
+
+	assert.Equal(t, topConfig, layer.Match(baseConfig.AsUnstructured()))
+	assert.Equal(t, baseSecret, layer.Match(baseSecret.AsUnstructured()))
+
+	// The base set is not modified.
+	assert.Equal(t, baseConfig, base.Match(baseConfig.AsUnstructured()))
+
+	// A layer with no base set only has its own fixtures.
+	assert.Nil(t, NewLayeredSet(nil).Match(baseConfig.AsUnstructured()))
+}
+
+func TestSetGroup(t *testing.T) {
+	deployment := Fixture("apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: echo\n")
+	service := Fixture("apiVersion: v1\nkind: Service\nmetadata:\n  name: echo\n")
+
+	base := NewSet()
+	require.NoError(t, base.Insert(NewEntry(deployment, "", "echo")))
+	require.NoError(t, base.Insert(NewEntry(service, "", "echo")))
+
+	// Re-inserting a member doesn't duplicate it.
+	require.NoError(t, base.Insert(NewEntry(service, "", "echo")))
+
+	assert.Equal(t, []Fixture{deployment, service}, base.Group("echo"))
+
+	// A layered group replaces the base group.
+	layer := NewLayeredSet(base)
+	assert.Equal(t, []Fixture{deployment, service}, layer.Group("echo"))
+
+	require.NoError(t, layer.Insert(NewEntry(service, "", "echo")))
+	assert.Equal(t, []Fixture{service}, layer.Group("echo"))
+}
+
+func TestSetEntries(t *testing.T) {
+	config := Fixture("apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n")
+	secret := Fixture("apiVersion: v1\nkind: Secret\nmetadata:\n  name: secret\n  namespace: test\n")
+
+	base := NewSet()
+	require.NoError(t, base.Insert(NewEntry(config, "base.yaml", "base")))
+	require.NoError(t, base.Insert(NewEntry(secret, "base.yaml", "base")))
+
+	layer := NewLayeredSet(base)
+	require.NoError(t, layer.Insert(NewEntry(config, "top.yaml", "top")))
+
+	assert.Equal(t, []Entry{
+		{Key: KeyFor(config.AsUnstructured()), Fixture: config, Source: "top.yaml", Groups: []string{"top"}},
+		{Key: KeyFor(secret.AsUnstructured()), Fixture: secret, Source: "base.yaml", Groups: []string{"base"}},
+	}, layer.Entries())
+
+	assert.Equal(t,
+		[]Key{{APIVersion: "v1", Kind: "ConfigMap", Name: "config"}},
+		Closest(layer, Key{APIVersion: "v1", Kind: "ConfigMap", Name: "confgi"}, 3))
+
+	assert.Empty(t, Closest(layer, Key{APIVersion: "apps/v1", Kind: "Deployment", Name: "echo"}, 3))
+}
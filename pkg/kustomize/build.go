@@ -0,0 +1,60 @@
+// Package kustomize builds Kubernetes objects from kustomization
+// directories.
+package kustomize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/kustomize/api/filesys"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// FileNames are the recognized names of the kustomization file
+// in a kustomization directory.
+var FileNames = konfig.RecognizedKustomizationFileNames()
+
+// IsKustomizationDir returns whether the given directory contains
+// a kustomization file.
+func IsKustomizationDir(dir string) bool {
+	for _, name := range FileNames {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Build builds the kustomization in the given directory in-process
+// with the kustomize API, exactly as "kustomize build" would, and
+// returns the resulting objects.
+func Build(dir string) ([]*yaml.RNode, error) {
+	k := krusty.MakeKustomizer(filesys.MakeFsOnDisk(), krusty.MakeDefaultOptions())
+
+	resources, err := k.Run(dir)
+	if err != nil {
+		return nil, fmt.Errorf("kustomize build %q: %w", dir, err)
+	}
+
+	nodes := make([]*yaml.RNode, 0, resources.Size())
+
+	for _, r := range resources.Resources() {
+		data, err := r.AsYAML()
+		if err != nil {
+			return nil, fmt.Errorf("kustomize build %q: %w", dir, err)
+		}
+
+		n, err := yaml.Parse(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("kustomize build %q: %w", dir, err)
+		}
+
+		nodes = append(nodes, n)
+	}
+
+	return nodes, nil
+}
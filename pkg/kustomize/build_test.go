@@ -0,0 +1,180 @@
+package kustomize
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+func TestBuild(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(name string, data string) {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(t, ioutil.WriteFile(p, []byte(data), 0644))
+	}
+
+	write("base/kustomization.yaml", `
+resources:
+- deployment.yaml
+- service.yaml
+`)
+
+	write("base/deployment.yaml", `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: echo
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: echo
+  template:
+    metadata:
+      labels:
+        app: echo
+    spec:
+      containers:
+      - name: echo
+        image: echo:v1
+        envFrom:
+        - configMapRef:
+            name: echo-config
+`)
+
+	write("base/service.yaml", `
+apiVersion: v1
+kind: Service
+metadata:
+  name: echo
+spec:
+  selector:
+    app: echo
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: echo
+`)
+
+	write("overlay/kustomization.yaml", `
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+namespace: test
+namePrefix: test-
+commonLabels:
+  tier: web
+commonAnnotations:
+  owner: modden
+resources:
+- ../base
+patchesStrategicMerge:
+- replicas.yaml
+configMapGenerator:
+- name: echo-config
+  literals:
+  - greeting=hello
+images:
+- name: echo
+  newTag: v2
+`)
+
+	write("overlay/replicas.yaml", `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: echo
+spec:
+  replicas: 3
+`)
+
+	objects, err := Build(filepath.Join(dir, "overlay"))
+	require.NoError(t, err)
+	require.Len(t, objects, 4)
+
+	get := func(o *yaml.RNode, path ...string) string {
+		n, err := o.Pipe(yaml.Lookup(path...))
+		require.NoError(t, err)
+		if n == nil {
+			return ""
+		}
+		return yaml.GetValue(n)
+	}
+
+	kinds := map[string]*yaml.RNode{}
+	for _, o := range objects {
+		kinds[get(o, "kind")] = o
+	}
+
+	deployment := kinds["Deployment"]
+	require.NotNil(t, deployment)
+	assert.Equal(t, "test-echo", get(deployment, "metadata", "name"))
+	assert.Equal(t, "test", get(deployment, "metadata", "namespace"))
+	assert.Equal(t, "3", get(deployment, "spec", "replicas"))
+	assert.Equal(t, "web", get(deployment, "metadata", "labels", "tier"))
+	assert.Equal(t, "web", get(deployment, "spec", "selector", "matchLabels", "tier"))
+	assert.Equal(t, "web", get(deployment, "spec", "template", "metadata", "labels", "tier"))
+	assert.Equal(t, "modden", get(deployment, "metadata", "annotations", "owner"))
+	assert.Equal(t, "echo:v2", get(deployment, "spec", "template", "spec", "containers", "[name=echo]", "image"))
+
+	// Generated objects are named with a content hash, and
+	// references to them are updated.
+	config := kinds["ConfigMap"]
+	require.NotNil(t, config)
+	assert.Regexp(t, "^test-echo-config-[a-z0-9]+$", get(config, "metadata", "name"))
+	assert.Equal(t, "hello", get(config, "data", "greeting"))
+
+	container, err := deployment.Pipe(yaml.Lookup("spec", "template", "spec", "containers", "[name=echo]"))
+	require.NoError(t, err)
+	envFrom, err := container.Pipe(yaml.Lookup("envFrom"))
+	require.NoError(t, err)
+	elements, err := envFrom.Elements()
+	require.NoError(t, err)
+	require.Len(t, elements, 1)
+	assert.Equal(t, get(config, "metadata", "name"), get(elements[0], "configMapRef", "name"))
+
+	service := kinds["Service"]
+	require.NotNil(t, service)
+	assert.Equal(t, "test-echo", get(service, "metadata", "name"))
+	assert.Equal(t, "web", get(service, "spec", "selector", "tier"))
+
+	namespace := kinds["Namespace"]
+	require.NotNil(t, namespace)
+	assert.Equal(t, "", get(namespace, "metadata", "namespace"))
+
+	// Patches must match an object.
+	write("badpatch/kustomization.yaml", `
+resources:
+- ../base
+patchesStrategicMerge:
+- ../overlay/replicas.yaml
+- patch.yaml
+`)
+
+	write("badpatch/patch.yaml", `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: missing
+`)
+
+	_, err = Build(filepath.Join(dir, "badpatch"))
+	assert.Error(t, err)
+
+	// Missing directories are errors.
+	_, err = Build(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+
+	assert.True(t, IsKustomizationDir(filepath.Join(dir, "base")))
+	assert.False(t, IsKustomizationDir(dir))
+	assert.False(t, IsKustomizationDir(filepath.Join(dir, "missing")))
+}
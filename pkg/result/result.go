@@ -22,9 +22,9 @@ const SeveritySkip Severity = "Skip"
 
 // Result ...
 type Result struct {
-	Severity  Severity
-	Message   string
-	Timestamp time.Time
+	Severity  Severity  `json:"severity"`
+	Message   string    `json:"message"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 // IsTerminal returns true if this result should end the test.
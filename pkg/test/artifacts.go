@@ -0,0 +1,310 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/format"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// DiagnosticArtifacts is the Step.Diagnostics key for the paths of
+// the artifact files that were written during the step.
+const DiagnosticArtifacts = "artifacts"
+
+// PropertyArtifacts is the document property for the paths of the
+// failure artifacts that were collected at the end of the document.
+const PropertyArtifacts = "artifacts"
+
+// podLogFunc fetches the logs for a container in a pod.
+type podLogFunc func(pod *v1.Pod, container string, previous bool) ([]byte, error)
+
+// writePodLogs writes the logs for each container in the given
+// pods to files in the artifacts directory. The logs are written
+// to "$dir/$namespace/$pod/$container.log", and the logs of the
+// previous instance of a restarted container are written to
+// "$dir/$namespace/$pod/$container.previous.log". It returns the
+// paths of the log files and the results of collecting the logs.
+func writePodLogs(dir string, pods []v1.Pod, logs podLogFunc) ([]string, []result.Result) {
+	var paths []string
+	var results []result.Result
+
+	for i := range pods {
+		pod := &pods[i]
+		podDir := filepath.Join(dir, pod.GetNamespace(), pod.GetName())
+
+		restarts := map[string]int32{}
+		for _, s := range pod.Status.InitContainerStatuses {
+			restarts[s.Name] = s.RestartCount
+		}
+		for _, s := range pod.Status.ContainerStatuses {
+			restarts[s.Name] = s.RestartCount
+		}
+
+		var containers []string
+		for _, c := range pod.Spec.InitContainers {
+			containers = append(containers, c.Name)
+		}
+		for _, c := range pod.Spec.Containers {
+			containers = append(containers, c.Name)
+		}
+
+		for _, c := range containers {
+			previous := []bool{false}
+			if restarts[c] > 0 {
+				previous = append(previous, true)
+			}
+
+			for _, p := range previous {
+				name := c + ".log"
+				if p {
+					name = c + ".previous.log"
+				}
+
+				data, err := logs(pod, c, p)
+				if err != nil {
+					// Containers that haven't started don't have
+					// logs, so this isn't an error.
+					results = append(results, result.Infof(
+						"no logs for container %q in pod '%s/%s': %s",
+						c, pod.GetNamespace(), pod.GetName(), err))
+					continue
+				}
+
+				if err := writeArtifact(filepath.Join(podDir, name), data); err != nil {
+					results = append(results, result.Errorf("%s", err))
+					continue
+				}
+
+				paths = append(paths, filepath.Join(podDir, name))
+				results = append(results, result.Infof(
+					"wrote logs for container %q in pod '%s/%s' to %s",
+					c, pod.GetNamespace(), pod.GetName(), filepath.Join(podDir, name)))
+			}
+		}
+	}
+
+	return paths, results
+}
+
+// writeArtifact writes data to the named artifact file, creating
+// any intermediate directories.
+func writeArtifact(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write artifact: %w", err)
+	}
+
+	return nil
+}
+
+// collectArtifacts collects debugging artifacts for a failed test
+// into a subdirectory of the artifacts directory that is named by
+// the test run ID. It returns the paths of the artifact files, or
+// the artifacts directory if no files were written.
+func (tc *testContext) collectArtifacts(s StepHandle) []string {
+	dir := filepath.Join(tc.artifactsDir, tc.envDriver.UniqueID())
+
+	s.Update(result.Infof("writing artifacts to %s", dir))
+
+	pods, err := tc.kubeDriver.PodsForRunID(tc.envDriver.UniqueID())
+	if err != nil {
+		s.Update(result.Errorf("failed to list pods: %s", err))
+		return []string{dir}
+	}
+
+	paths, results := writePodLogs(dir, pods, tc.kubeDriver.PodLogs)
+	s.Update(results...)
+
+	if len(paths) == 0 {
+		return []string{dir}
+	}
+
+	s.Diagnose(DiagnosticArtifacts, paths)
+	return paths
+}
+
+// stringsToInterfaces converts a string slice to a slice of
+// JSON-compatible values.
+func stringsToInterfaces(s []string) []interface{} {
+	values := make([]interface{}, len(s))
+	for i := range s {
+		values[i] = s[i]
+	}
+
+	return values
+}
+
+// checkState is the state that a check saw when it was evaluated.
+type checkState struct {
+	// Objects are the objects adopted by the object driver.
+	Objects []*unstructured.Unstructured
+	// Data is the whole Rego data document.
+	Data interface{}
+	// Module is the check module.
+	Module *ast.Module
+	// Input is the check input, which may be nil.
+	Input interface{}
+	// Trace is the trace of the last check evaluation, which
+	// may be empty.
+	Trace []string
+}
+
+// writeCheckState writes the check state to files in the given
+// directory. The objects are written to "objects.yaml", the data
+// document to "data.json", the check module to "check.rego" and
+// the check input (if any) to "input.json" and the check trace (if
+// any) to "trace.txt". It returns the paths of the files that were
+// written.
+func writeCheckState(dir string, state checkState) ([]string, error) {
+	objects := make([]*unstructured.Unstructured, len(state.Objects))
+	copy(objects, state.Objects)
+
+	// Sort the objects so that dumps are easy to compare.
+	sort.SliceStable(objects, func(i, j int) bool {
+		a := strings.Join([]string{objects[i].GetKind(), objects[i].GetNamespace(), objects[i].GetName()}, "/")
+		b := strings.Join([]string{objects[j].GetKind(), objects[j].GetNamespace(), objects[j].GetName()}, "/")
+		return a < b
+	})
+
+	buf := bytes.Buffer{}
+	for i, u := range objects {
+		data, err := yaml.Marshal(u.UnstructuredContent())
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s '%s/%s': %w",
+				u.GetKind(), u.GetNamespace(), u.GetName(), err)
+		}
+
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+
+		buf.Write(data)
+	}
+
+	paths := []string{
+		filepath.Join(dir, "objects.yaml"),
+		filepath.Join(dir, "data.json"),
+	}
+
+	if err := writeArtifact(paths[0], buf.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := writeJSONArtifact(paths[1], state.Data); err != nil {
+		return nil, err
+	}
+
+	if state.Module != nil {
+		source, err := format.Ast(state.Module)
+		if err != nil {
+			// Fall back to the unformatted module.
+			source = []byte(state.Module.String())
+		}
+
+		if err := writeArtifact(filepath.Join(dir, "check.rego"), source); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, filepath.Join(dir, "check.rego"))
+	}
+
+	if state.Input != nil {
+		if err := writeJSONArtifact(filepath.Join(dir, "input.json"), state.Input); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, filepath.Join(dir, "input.json"))
+	}
+
+	if len(state.Trace) > 0 {
+		trace := strings.Join(state.Trace, "\n") + "\n"
+		if err := writeArtifact(filepath.Join(dir, "trace.txt"), []byte(trace)); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, filepath.Join(dir, "trace.txt"))
+	}
+
+	return paths, nil
+}
+
+// writeJSONArtifact writes value to the named artifact file as
+// indented JSON.
+func writeJSONArtifact(path string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", filepath.Base(path), err)
+	}
+
+	return writeArtifact(path, append(data, '\n'))
+}
+
+// stepArtifactsName returns a directory name for the artifacts of
+// the numbered step, e.g. "step-03-running-object-update-check".
+func stepArtifactsName(n int, desc string) string {
+	slug := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, desc)
+
+	// Collapse runs of separators.
+	for strings.Contains(slug, "--") {
+		slug = strings.ReplaceAll(slug, "--", "-")
+	}
+
+	return fmt.Sprintf("step-%02d-%s", n, strings.Trim(slug, "-"))
+}
+
+// dumpCheckState writes the state that a failing check saw into a
+// subdirectory of the artifacts directory that is named by the
+// test run ID and the step. Nothing is written if no artifacts
+// directory was given.
+func (tc *testContext) dumpCheckState(s *testStep, check *ast.Module, input interface{}, trace []string) {
+	if tc.artifactsDir == "" {
+		return
+	}
+
+	dir := filepath.Join(tc.artifactsDir, tc.envDriver.UniqueID(),
+		stepArtifactsName(s.num, s.desc))
+
+	data, err := tc.regoDriver.ReadItem("/")
+	if err != nil {
+		s.Update(result.Errorf("failed to read Rego data document: %s", err))
+		return
+	}
+
+	state := checkState{
+		Objects: tc.objectDriver.AdoptedObjects(),
+		Data:    data,
+		Module:  check,
+		Input:   input,
+		Trace:   trace,
+	}
+
+	paths, err := writeCheckState(dir, state)
+	if err != nil {
+		s.Update(result.Errorf("%s", err))
+		return
+	}
+
+	s.Update(result.Infof("wrote check state to %s", dir))
+	s.Diagnose(DiagnosticArtifacts, paths)
+}
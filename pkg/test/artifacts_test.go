@@ -0,0 +1,186 @@
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestWritePodLogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	pods := []v1.Pod{{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "httpbin"},
+		Spec: v1.PodSpec{
+			InitContainers: []v1.Container{{Name: "init"}},
+			Containers:     []v1.Container{{Name: "httpbin"}, {Name: "pending"}},
+		},
+		Status: v1.PodStatus{
+			ContainerStatuses: []v1.ContainerStatus{{Name: "httpbin", RestartCount: 1}},
+		},
+	}}
+
+	logs := func(pod *v1.Pod, container string, previous bool) ([]byte, error) {
+		if container == "pending" {
+			return nil, errors.New("container is waiting to start")
+		}
+
+		if previous {
+			return []byte("previous " + container), nil
+		}
+
+		return []byte("current " + container), nil
+	}
+
+	paths, results := writePodLogs(dir, pods, logs)
+	assert.False(t, result.Contains(results, result.SeverityError))
+	assert.Len(t, paths, 3)
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "test", "httpbin", name))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+
+	assert.Equal(t, "current init", read("init.log"))
+	assert.Equal(t, "", read("init.previous.log"))
+	assert.Equal(t, "current httpbin", read("httpbin.log"))
+	assert.Equal(t, "previous httpbin", read("httpbin.previous.log"))
+	assert.Equal(t, "", read("pending.log"))
+}
+
+func TestFailureDocument(t *testing.T) {
+	f := &failureDocument{}
+	d := stackDocuments(&failureDocument{}, f)
+
+	s := d.NewStep("step")
+	s.Update(result.Infof("info"), result.Skipf("skip"))
+	assert.False(t, f.Failed())
+
+	s.Update(result.Errorf("error"))
+	assert.True(t, f.Failed())
+	assert.True(t, d.ShouldContinue())
+}
+
+func TestWriteCheckState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	object := func(kind string, name string) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{}
+		u.SetAPIVersion("v1")
+		u.SetKind(kind)
+		u.SetNamespace("test")
+		u.SetName(name)
+		return u
+	}
+
+	state := checkState{
+		Objects: []*unstructured.Unstructured{
+			object("Service", "httpbin"),
+			object("ConfigMap", "httpbin"),
+		},
+		Data:   map[string]interface{}{"resources": map[string]interface{}{}},
+		Module: ast.MustParseModule("package check\nerror[msg] { msg := \"failed\" }"),
+	}
+
+	paths, err := writeCheckState(dir, state)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "objects.yaml"),
+		filepath.Join(dir, "data.json"),
+		filepath.Join(dir, "check.rego"),
+	}, paths)
+
+	objects, err := ioutil.ReadFile(filepath.Join(dir, "objects.yaml"))
+	require.NoError(t, err)
+	assert.Regexp(t, "(?s)kind: ConfigMap.*\n---\n.*kind: Service", string(objects))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "data.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"resources": {}}`, string(data))
+
+	check, err := ioutil.ReadFile(filepath.Join(dir, "check.rego"))
+	require.NoError(t, err)
+	assert.Contains(t, string(check), "package check")
+
+	// There was no input, so there is no input file.
+	_, err = os.Stat(filepath.Join(dir, "input.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	state.Input = map[string]interface{}{"error": nil}
+	paths, err = writeCheckState(dir, state)
+	require.NoError(t, err)
+	assert.Contains(t, paths, filepath.Join(dir, "input.json"))
+
+	input, err := ioutil.ReadFile(filepath.Join(dir, "input.json"))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"error": null}`, string(input))
+
+	state.Trace = []string{"Enter data.check.error", "| Exit data.check.error"}
+	paths, err = writeCheckState(dir, state)
+	require.NoError(t, err)
+	assert.Contains(t, paths, filepath.Join(dir, "trace.txt"))
+
+	trace, err := ioutil.ReadFile(filepath.Join(dir, "trace.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "Enter data.check.error\n| Exit data.check.error\n", string(trace))
+}
+
+func TestStepArtifactsName(t *testing.T) {
+	assert.Equal(t, "step-03-running-object-update-check",
+		stepArtifactsName(3, "running object update check"))
+	assert.Equal(t, "step-12-running-rego-check-lines-10-22",
+		stepArtifactsName(12, "running Rego check lines 10-22"))
+	assert.Equal(t, "step-01-a-b",
+		stepArtifactsName(1, "  a // b  "))
+}
+
+func TestDumpCheckStateStepName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "artifacts")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	r := &defaultRecorder{}
+	d := r.NewDocument("test")
+
+	tc := testContext{
+		regoDriver:   driver.NewRegoDriver(),
+		envDriver:    driver.NewEnvironment(nil),
+		objectDriver: driver.NewObjectDriver(&driver.KubeClient{}),
+		doc:          d,
+		events:       newEventLog("test"),
+		artifactsDir: dir,
+	}
+
+	check := ast.MustParseModule("package check\nerror[msg] { msg := \"failed\" }")
+
+	tc.step("first step", func(s *testStep) {
+		// Starting another step doesn't change the
+		// artifacts directory of this one.
+		tc.step("second step", func(*testStep) {})
+		tc.dumpCheckState(s, check, nil, nil)
+	})
+
+	d.Close()
+
+	_, err = os.Stat(filepath.Join(dir, tc.envDriver.UniqueID(), "step-01-first-step", "check.rego"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,328 @@
+package test
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jpeach/modden/pkg/doc"
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/must"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+// Coverage aggregates the Rego coverage of the checks in a test run.
+// Coverage is reported for the policy modules that were given with
+// RegoModuleOpt and for the Rego fragments of each test document.
+type Coverage struct {
+	tracer *driver.RegoCoverage
+
+	lock      sync.Mutex
+	modules   map[string][]*ast.Module
+	fragments map[string][]doc.Location
+	seen      map[*ast.Module]bool
+}
+
+// NewCoverage returns a new, empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{
+		tracer:    driver.NewRegoCoverage(),
+		modules:   map[string][]*ast.Module{},
+		fragments: map[string][]doc.Location{},
+		seen:      map[*ast.Module]bool{},
+	}
+}
+
+// CoverageOpt records the Rego coverage of the test run in c.
+func CoverageOpt(c *Coverage) RunOpt {
+	return RunOpt(func(tc *testContext) {
+		tc.coverage = c
+		tc.regoDriver.Cover(c.tracer)
+	})
+}
+
+// addModule adds a module to the coverage report. Modules that were
+// already added, or that have no file name, are ignored. Many modules
+// can have the same file name, since each Rego fragment in a document
+// is a separate module, so modules are grouped by file.
+func (c *Coverage) addModule(m *ast.Module) {
+	file := m.Package.Loc().File
+	if file == "" || c.seen[m] {
+		return
+	}
+
+	c.seen[m] = true
+	c.modules[file] = append(c.modules[file], m)
+}
+
+// addDocument adds the policy modules and the Rego fragments of the
+// test document to the coverage report.
+func (c *Coverage) addDocument(d *doc.Document, policies []*ast.Module) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, m := range policies {
+		c.addModule(m)
+	}
+
+	for _, p := range d.Parts {
+		if p.Type != doc.FragmentTypeModule || p.Location.File == "" {
+			continue
+		}
+
+		// Fragments that are included by more than one
+		// document are parsed each time, so only add the
+		// first instance.
+		if containsLocation(c.fragments[p.Location.File], p.Location) {
+			continue
+		}
+
+		c.fragments[p.Location.File] = append(c.fragments[p.Location.File], p.Location)
+		c.addModule(p.Rego())
+	}
+}
+
+// addCheck adds an object check to the coverage report. Checks whose
+// location in a test document is unknown are ignored.
+func (c *Coverage) addCheck(m *ast.Module, loc doc.Location) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if loc.File == "" || containsLocation(c.fragments[loc.File], loc) {
+		return
+	}
+
+	c.fragments[loc.File] = append(c.fragments[loc.File], loc)
+	c.addModule(m)
+}
+
+// containsLocation returns whether the locations contain the same
+// lines of the same file as loc, whether or not they were included.
+func containsLocation(locations []doc.Location, loc doc.Location) bool {
+	for _, l := range locations {
+		if l.File == loc.File && l.Start == loc.Start && l.End == loc.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+// LineRange is an inclusive range of lines.
+type LineRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func (r LineRange) String() string {
+	if r.Start == r.End {
+		return fmt.Sprintf("%d", r.Start)
+	}
+
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// FragmentCoverage describes the coverage of a Rego fragment in a
+// test document.
+type FragmentCoverage struct {
+	Start      int         `json:"start"`
+	End        int         `json:"end"`
+	Coverage   float64     `json:"coverage"`
+	Covered    []LineRange `json:"covered,omitempty"`
+	NotCovered []LineRange `json:"notCovered,omitempty"`
+}
+
+// FileCoverage describes the coverage of a Rego policy file or
+// test document. Lines are counted if they contain a rule head or
+// an expression.
+type FileCoverage struct {
+	File       string             `json:"file"`
+	Coverage   float64            `json:"coverage"`
+	Covered    []LineRange        `json:"covered,omitempty"`
+	NotCovered []LineRange        `json:"notCovered,omitempty"`
+	Fragments  []FragmentCoverage `json:"fragments,omitempty"`
+}
+
+// CoverageReport describes the Rego coverage of a test run.
+type CoverageReport struct {
+	Coverage float64        `json:"coverage"`
+	Files    []FileCoverage `json:"files"`
+}
+
+// percent returns n as a percentage of total, rounded down to one
+// decimal place so that partial coverage is never shown as 100%.
+func percent(n int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+
+	return float64(n*1000/total) / 10
+}
+
+// lineRanges converts a set of lines to a sorted slice of ranges.
+func lineRanges(lines map[int]bool) []LineRange {
+	rows := make([]int, 0, len(lines))
+	for r := range lines {
+		rows = append(rows, r)
+	}
+
+	sort.Ints(rows)
+
+	var ranges []LineRange
+	for _, r := range rows {
+		if n := len(ranges); n > 0 && ranges[n-1].End == r-1 {
+			ranges[n-1].End = r
+			continue
+		}
+
+		ranges = append(ranges, LineRange{Start: r, End: r})
+	}
+
+	return ranges
+}
+
+// moduleLines adds the lines of the module that contain a rule head
+// or an expression, which are the lines that OPA traces for coverage,
+// to the set of lines.
+func moduleLines(m *ast.Module, lines map[int]bool) {
+	ast.WalkRules(m, func(r *ast.Rule) bool {
+		if loc := r.Head.Location; loc != nil {
+			lines[loc.Row] = true
+		}
+		return false
+	})
+
+	ast.WalkExprs(m, func(x *ast.Expr) bool {
+		if _, ok := x.Terms.(*ast.SomeDecl); !ok && x.Location != nil {
+			lines[x.Location.Row] = true
+		}
+		return false
+	})
+}
+
+// Report returns the coverage report for all the evaluations so far.
+func (c *Coverage) Report() CoverageReport {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	report := CoverageReport{Files: []FileCoverage{}}
+
+	// We don't pass any modules to the OPA report, since it
+	// can only take one module per file. We just want to know
+	// which lines were hit.
+	hits := c.tracer.Report(nil)
+
+	files := make([]string, 0, len(c.modules))
+	for f := range c.modules {
+		files = append(files, f)
+	}
+
+	sort.Strings(files)
+
+	totalCovered := 0
+	totalLines := 0
+
+	for _, f := range files {
+		lines := map[int]bool{}
+		for _, m := range c.modules[f] {
+			moduleLines(m, lines)
+		}
+
+		covered := map[int]bool{}
+		notCovered := map[int]bool{}
+
+		for row := range lines {
+			if hits.IsCovered(f, row) {
+				covered[row] = true
+			} else {
+				notCovered[row] = true
+			}
+		}
+
+		fc := FileCoverage{
+			File:       f,
+			Coverage:   percent(len(covered), len(lines)),
+			Covered:    lineRanges(covered),
+			NotCovered: lineRanges(notCovered),
+		}
+
+		fragments := make([]doc.Location, len(c.fragments[f]))
+		copy(fragments, c.fragments[f])
+
+		sort.Slice(fragments, func(i, j int) bool {
+			return fragments[i].Start < fragments[j].Start
+		})
+
+		for _, loc := range fragments {
+			fragCovered := map[int]bool{}
+			fragNotCovered := map[int]bool{}
+
+			for row := range lines {
+				if row < loc.Start || row > loc.End {
+					continue
+				}
+
+				if covered[row] {
+					fragCovered[row] = true
+				} else {
+					fragNotCovered[row] = true
+				}
+			}
+
+			fc.Fragments = append(fc.Fragments, FragmentCoverage{
+				Start:      loc.Start,
+				End:        loc.End,
+				Coverage:   percent(len(fragCovered), len(fragCovered)+len(fragNotCovered)),
+				Covered:    lineRanges(fragCovered),
+				NotCovered: lineRanges(fragNotCovered),
+			})
+		}
+
+		totalCovered += len(covered)
+		totalLines += len(lines)
+
+		report.Files = append(report.Files, fc)
+	}
+
+	report.Coverage = percent(totalCovered, totalLines)
+
+	return report
+}
+
+// formatRanges formats line ranges as a comma-separated list.
+func formatRanges(ranges []LineRange) string {
+	s := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		s = append(s, r.String())
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// WriteCoverageText writes the coverage report as text, with one
+// line for each file and for each Rego fragment in a test document.
+// Each line is prefixed by the given string.
+func WriteCoverageText(w io.Writer, prefix string, report CoverageReport) {
+	must.Int(fmt.Fprintf(w, "%sRego coverage: %.1f%%\n", prefix, report.Coverage))
+
+	for _, f := range report.Files {
+		line := fmt.Sprintf("%s  %6.1f%%  %s", prefix, f.Coverage, f.File)
+		if len(f.Fragments) == 0 && len(f.NotCovered) > 0 {
+			line += fmt.Sprintf(" (not covered: %s)", formatRanges(f.NotCovered))
+		}
+
+		must.Int(fmt.Fprintln(w, line))
+
+		for _, frag := range f.Fragments {
+			line := fmt.Sprintf("%s  %6.1f%%    lines %d-%d", prefix, frag.Coverage, frag.Start, frag.End)
+			if len(frag.NotCovered) > 0 {
+				line += fmt.Sprintf(" (not covered: %s)", formatRanges(frag.NotCovered))
+			}
+
+			must.Int(fmt.Fprintln(w, line))
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/doc"
+	"github.com/jpeach/modden/pkg/driver"
+
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoverageReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coverage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`error[msg] {
+  input.fail
+  msg := "failed"
+}
+---
+error[msg] {
+  input.other
+  msg := "other"
+}
+`), 0644))
+
+	d, err := doc.ReadFile(path)
+	require.NoError(t, err)
+
+	for i := range d.Parts {
+		_, err := d.Parts[i].Decode()
+		require.NoError(t, err)
+	}
+
+	c := NewCoverage()
+	tc := testContext{regoDriver: driver.NewRegoDriver()}
+	CoverageOpt(c)(&tc)
+
+	compiler, err := CompileDocument(d, nil)
+	require.NoError(t, err)
+
+	c.addDocument(d, nil)
+
+	// Only evaluate the first fragment. Since there is no
+	// input, only its first expression is evaluated.
+	_, _, err = runCheck(tc.regoDriver, d.Parts[0].Rego(), time.Millisecond, nil, rego.Compiler(compiler))
+	require.NoError(t, err)
+
+	report := c.Report()
+	require.Len(t, report.Files, 1)
+
+	f := report.Files[0]
+	assert.Equal(t, path, f.File)
+	assert.Equal(t, 16.6, f.Coverage)
+	assert.Equal(t, []LineRange{{Start: 2, End: 2}}, f.Covered)
+	assert.Equal(t, []LineRange{{Start: 1, End: 1}, {Start: 3, End: 3}, {Start: 6, End: 8}}, f.NotCovered)
+
+	require.Len(t, f.Fragments, 2)
+	assert.Equal(t, 33.3, f.Fragments[0].Coverage)
+	assert.Equal(t, 0.0, f.Fragments[1].Coverage)
+	assert.Equal(t, 16.6, report.Coverage)
+
+	buf := bytes.Buffer{}
+	WriteCoverageText(&buf, "# ", report)
+	assert.Equal(t, `# Rego coverage: 16.6%
+#     16.6%  `+path+`
+#     33.3%    lines 1-4 (not covered: 1, 3)
+#      0.0%    lines 6-9 (not covered: 6-8)
+`, buf.String())
+}
+
+func TestCoverageObjectCheck(t *testing.T) {
+	c := NewCoverage()
+	tc := testContext{regoDriver: driver.NewRegoDriver()}
+	CoverageOpt(c)(&tc)
+
+	env := driver.NewEnvironment(nil)
+
+	hydrate := func(loc doc.Location) *driver.Object {
+		objs, err := env.HydrateObjectsAt(loc, []byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: |
+  error[msg] {
+    input.fail
+    msg := "failed"
+  }
+`))
+		require.NoError(t, err)
+		require.Len(t, objs, 1)
+		return objs[0]
+	}
+
+	obj := hydrate(doc.Location{File: "test.yaml", Start: 10, End: 18})
+	c.addCheck(obj.Check, obj.CheckLocation)
+
+	// Checks that are hydrated again, e.g. because the document
+	// was included twice, are only added once.
+	again := hydrate(doc.Location{File: "test.yaml", Start: 10, End: 18})
+	c.addCheck(again.Check, again.CheckLocation)
+
+	// Checks without a document location are ignored.
+	objs, err := env.HydrateObjects([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: checked
+$check: 'error[msg] { msg := "failed" }'
+`))
+	require.NoError(t, err)
+	c.addCheck(objs[0].Check, objs[0].CheckLocation)
+
+	_, _, err = runCheck(tc.regoDriver, obj.Check, time.Millisecond, nil,
+		rego.ParsedModule(obj.Check))
+	require.NoError(t, err)
+
+	report := c.Report()
+	require.Len(t, report.Files, 1)
+
+	f := report.Files[0]
+	assert.Equal(t, "test.yaml", f.File)
+	assert.Equal(t, []LineRange{{Start: 16, End: 16}}, f.Covered)
+	assert.Equal(t, []LineRange{{Start: 15, End: 15}, {Start: 17, End: 17}}, f.NotCovered)
+
+	require.Len(t, f.Fragments, 1)
+	assert.Equal(t, 15, f.Fragments[0].Start)
+	assert.Equal(t, 18, f.Fragments[0].End)
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jpeach/modden/pkg/filter"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DiagnosticEvents is the Step.Diagnostics key for the Kubernetes
+// events that are related to the objects in a test.
+const DiagnosticEvents = "events"
+
+// eventResource is the Kubernetes core v1 Event resource.
+var eventResource = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+
+// isEvent returns whether the object is a core v1 Event.
+func isEvent(u *unstructured.Unstructured) bool {
+	return u.GetAPIVersion() == "v1" && u.GetKind() == "Event"
+}
+
+// formatEvent formats an event as a single line, e.g.
+// "Warning FailedScheduling pod/httpbin-xyz: 0/1 nodes are available".
+func formatEvent(u *unstructured.Unstructured) string {
+	eventType, _, _ := unstructured.NestedString(u.Object, "type")
+	reason, _, _ := unstructured.NestedString(u.Object, "reason")
+	message, _, _ := unstructured.NestedString(u.Object, "message")
+	kind, _, _ := unstructured.NestedString(u.Object, "involvedObject", "kind")
+	name, _, _ := unstructured.NestedString(u.Object, "involvedObject", "name")
+
+	return fmt.Sprintf("%s %s %s/%s: %s",
+		eventType, reason, strings.ToLower(kind), name, strings.TrimSpace(message))
+}
+
+// eventLog collects the Kubernetes events that are related to the
+// objects in a test. An event is related if its involved object was
+// adopted by the object driver, or if the involved object has been
+// seen with the run ID of the test (e.g. Pods created from a
+// template that was annotated with the run ID). Since an event can
+// arrive before its involved object is related to the test, events
+// that aren't related are kept until their object is seen again.
+type eventLog struct {
+	lock sync.Mutex
+
+	runID      string
+	runObjects map[types.UID]bool
+
+	events []*unstructured.Unstructured
+	index  map[types.UID]int
+
+	// pending holds the events that are not related to the
+	// test, in the order they arrived, indexed by involved
+	// object UID.
+	pending map[types.UID][]*unstructured.Unstructured
+
+	// seq is incremented each time an event is recorded, and
+	// updated holds the seq of the last update to each event.
+	seq     int
+	updated []int
+}
+
+func newEventLog(runID string) *eventLog {
+	return &eventLog{
+		runID:      runID,
+		runObjects: map[types.UID]bool{},
+		index:      map[types.UID]int{},
+		pending:    map[types.UID][]*unstructured.Unstructured{},
+	}
+}
+
+// AddObject records the object if it has the run ID of the test or
+// was adopted. Any pending events for the object are now related to
+// the test, so they are recorded and returned.
+func (l *eventLog) AddObject(u *unstructured.Unstructured, isAdopted func(types.UID) bool) []*unstructured.Unstructured {
+	uid := u.GetUID()
+
+	if filter.ObjectRunID(u) != l.runID && !isAdopted(uid) {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.runObjects[uid] = true
+
+	events := l.pending[uid]
+	for _, e := range events {
+		l.record(e)
+	}
+
+	delete(l.pending, uid)
+
+	return events
+}
+
+// AddEvent records the event if it is related to the test, and
+// returns whether it was recorded. Updated events replace the
+// previous version of the event. Events that are not related to
+// the test are kept pending until their involved object is added.
+func (l *eventLog) AddEvent(u *unstructured.Unstructured, isAdopted func(types.UID) bool) bool {
+	str, _, _ := unstructured.NestedString(u.Object, "involvedObject", "uid")
+	uid := types.UID(str)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if !l.runObjects[uid] && !isAdopted(uid) {
+		l.pending[uid] = replaceEvent(l.pending[uid], u.DeepCopy())
+		return false
+	}
+
+	l.record(u.DeepCopy())
+	return true
+}
+
+// replaceEvent replaces the event with the same UID in events, or
+// appends the event if there isn't one.
+func replaceEvent(events []*unstructured.Unstructured, u *unstructured.Unstructured) []*unstructured.Unstructured {
+	for i, e := range events {
+		if e.GetUID() == u.GetUID() {
+			events[i] = u
+			return events
+		}
+	}
+
+	return append(events, u)
+}
+
+// record adds the event to the log. The caller must hold the lock.
+func (l *eventLog) record(u *unstructured.Unstructured) {
+	l.seq++
+
+	if i, ok := l.index[u.GetUID()]; ok {
+		l.events[i] = u
+		l.updated[i] = l.seq
+	} else {
+		l.index[u.GetUID()] = len(l.events)
+		l.events = append(l.events, u)
+		l.updated = append(l.updated, l.seq)
+	}
+}
+
+// Mark returns the current position in the log. Pass the mark to
+// LinesSince to get the events that were recorded after it.
+func (l *eventLog) Mark() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return l.seq
+}
+
+// LinesSince returns the events that were recorded or updated
+// after the given mark, formatted with formatEvent.
+func (l *eventLog) LinesSince(mark int) []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var lines []string
+	for i, e := range l.events {
+		if l.updated[i] > mark {
+			lines = append(lines, formatEvent(e))
+		}
+	}
+
+	return lines
+}
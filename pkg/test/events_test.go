@@ -0,0 +1,136 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/jpeach/modden/pkg/filter"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newEvent(uid string, involved string, reason string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Event",
+		"metadata": map[string]interface{}{
+			"name": "event-" + uid,
+			"uid":  uid,
+		},
+		"type":    "Warning",
+		"reason":  reason,
+		"message": "0/1 nodes are available\n",
+		"involvedObject": map[string]interface{}{
+			"kind": "Pod",
+			"name": "httpbin",
+			"uid":  involved,
+		},
+	}}
+}
+
+func TestEventLog(t *testing.T) {
+	adopted := func(uid types.UID) bool { return uid == "adopted" }
+
+	l := newEventLog("run-1")
+
+	// Events for unrelated objects are ignored.
+	assert.False(t, l.AddEvent(newEvent("1", "other", "Unrelated"), adopted))
+
+	// Events for adopted objects are recorded.
+	assert.True(t, l.AddEvent(newEvent("2", "adopted", "FailedScheduling"), adopted))
+
+	// Events for objects with the run ID are recorded.
+	pod := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":        "httpbin",
+			"uid":         "pod",
+			"annotations": map[string]interface{}{filter.LabelRunID: "run-1"},
+		},
+	}}
+
+	// Events that arrive before their object is related to the
+	// test are kept, and recorded once the object is added.
+	mark := l.Mark()
+	assert.False(t, l.AddEvent(newEvent("3", "pod", "Scheduled"), adopted))
+	assert.False(t, l.AddEvent(newEvent("3", "pod", "Pulling"), adopted))
+	assert.Empty(t, l.LinesSince(mark))
+
+	recorded := l.AddObject(pod, adopted)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "Pulling", recorded[0].Object["reason"])
+	assert.Equal(t, []string{
+		"Warning Pulling pod/httpbin: 0/1 nodes are available",
+	}, l.LinesSince(mark))
+
+	// Adding the object again doesn't record the events again.
+	assert.Empty(t, l.AddObject(pod, adopted))
+
+	mark = l.Mark()
+	assert.True(t, l.AddEvent(newEvent("3", "pod", "Pulling"), adopted))
+
+	// Only events since the mark are returned.
+	assert.Equal(t, []string{
+		"Warning Pulling pod/httpbin: 0/1 nodes are available",
+	}, l.LinesSince(mark))
+
+	// Updated events replace the original, and are returned
+	// again since they changed after the mark.
+	mark = l.Mark()
+	assert.True(t, l.AddEvent(newEvent("2", "adopted", "BackOff"), adopted))
+
+	assert.Equal(t, []string{
+		"Warning BackOff pod/httpbin: 0/1 nodes are available",
+	}, l.LinesSince(mark))
+
+	assert.Equal(t, []string{
+		"Warning BackOff pod/httpbin: 0/1 nodes are available",
+		"Warning Pulling pod/httpbin: 0/1 nodes are available",
+	}, l.LinesSince(0))
+
+	assert.Empty(t, l.LinesSince(l.Mark()))
+}
+
+func TestFormatDiagnostics(t *testing.T) {
+	assert.Equal(t, []string{
+		"count: 2",
+		"events: first",
+		"events: second",
+	}, formatDiagnostics(map[string]interface{}{
+		"events": []string{"first", "second"},
+		"count":  2,
+	}))
+}
+
+func TestEventLogAdoptedObject(t *testing.T) {
+	isAdopted := map[types.UID]bool{}
+	adopted := func(uid types.UID) bool { return isAdopted[uid] }
+
+	l := newEventLog("run-1")
+
+	// An object without the run ID isn't related to the test
+	// until it is adopted.
+	svc := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name": "httpbin",
+			"uid":  "svc",
+		},
+	}}
+
+	assert.False(t, l.AddEvent(newEvent("1", "svc", "SyncLoadBalancerFailed"), adopted))
+	assert.Empty(t, l.AddObject(svc, adopted))
+	assert.Empty(t, l.LinesSince(0))
+
+	isAdopted["svc"] = true
+
+	recorded := l.AddObject(svc, adopted)
+	require.Len(t, recorded, 1)
+	assert.Equal(t, []string{
+		"Warning SyncLoadBalancerFailed pod/httpbin: 0/1 nodes are available",
+	}, l.LinesSince(0))
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/result"
+	"github.com/jpeach/modden/pkg/utils"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// projectExpectation returns the subset of actual that has the same
+// shape as expected. Map keys that are not present in expected are
+// dropped. Each element of an expected array matches any element of
+// the actual array, so the order of the array elements doesn't matter.
+func projectExpectation(expected interface{}, actual interface{}) interface{} {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		actualMap, ok := actual.(map[string]interface{})
+		if !ok {
+			return actual
+		}
+
+		projection := map[string]interface{}{}
+
+		for k, v := range expected {
+			if a, ok := actualMap[k]; ok {
+				projection[k] = projectExpectation(v, a)
+			}
+		}
+
+		return projection
+
+	case []interface{}:
+		actualSlice, ok := actual.([]interface{})
+		if !ok {
+			return actual
+		}
+
+		projection := make([]interface{}, 0, len(expected))
+		used := map[int]bool{}
+
+		for _, e := range expected {
+			for i, a := range actualSlice {
+				if used[i] {
+					continue
+				}
+
+				if p := projectExpectation(e, a); reflect.DeepEqual(e, p) {
+					projection = append(projection, p)
+					used[i] = true
+					break
+				}
+			}
+		}
+
+		// Every expected element matched.
+		if len(projection) == len(expected) {
+			return projection
+		}
+
+		// No unordered match, so fall back to an ordered
+		// projection to get a useful diff.
+		if len(actualSlice) != len(expected) {
+			return actual
+		}
+
+		projection = make([]interface{}, len(expected))
+		for i := range expected {
+			projection[i] = projectExpectation(expected[i], actualSlice[i])
+		}
+
+		return projection
+
+	default:
+		return actual
+	}
+}
+
+// diffExpectation returns a structural diff between expected
+// and actual if actual is not a superset of expected. If actual
+// matches, the diff is empty.
+func diffExpectation(expected interface{}, actual interface{}) string {
+	projection := projectExpectation(expected, actual)
+	if reflect.DeepEqual(expected, projection) {
+		return ""
+	}
+
+	return cmp.Diff(expected, projection)
+}
+
+// expectObject polls the Rego data document until the stored copy
+// of the Kubernetes object matches the expected object u, or until
+// the timeout expires.
+func expectObject(
+	k *driver.KubeClient,
+	o driver.ObjectDriver,
+	c driver.RegoDriver,
+	u *unstructured.Unstructured,
+	timeout time.Duration) []result.Result {
+	gvr, err := k.ResourceForKind(u.GetObjectKind().GroupVersionKind())
+	if err != nil {
+		return []result.Result{result.Fatalf(
+			"failed to resolve resource for kind %s:%s: %s",
+			u.GetAPIVersion(), u.GetKind(), err)}
+	}
+
+	// Make sure that objects of this kind are being published
+	// into the data document.
+	if err := o.InformOn(gvr); err != nil {
+		return []result.Result{result.Fatalf(
+			"failed to start informer for %q: %s", gvr, err)}
+	}
+
+	var expected interface{} = u.UnstructuredContent()
+	if err := util.RoundTrip(&expected); err != nil {
+		return []result.Result{result.Fatalf(
+			"failed to convert expected object: %s", err)}
+	}
+
+	where := pathForResource(gvr.Resource, u)
+	diff := ""
+	startTime := time.Now()
+
+	for {
+		actual, err := c.ReadItem(where)
+		switch {
+		case err == nil:
+			diff = diffExpectation(expected, actual)
+			if diff == "" {
+				return []result.Result{result.Infof(
+					"%s '%s/%s' matched expectation",
+					u.GetKind(), utils.NamespaceOrDefault(u), u.GetName())}
+			}
+		case storage.IsNotFound(err):
+			diff = ""
+		default:
+			return []result.Result{result.Fatalf(
+				"failed to read %q: %s", where, err)}
+		}
+
+		if time.Since(startTime) > timeout {
+			break
+		}
+
+		time.Sleep(time.Millisecond * 500)
+	}
+
+	if diff == "" {
+		return []result.Result{result.Errorf(
+			"%s '%s/%s' not found",
+			u.GetKind(), utils.NamespaceOrDefault(u), u.GetName())}
+	}
+
+	return []result.Result{result.Errorf(
+		"%s '%s/%s' does not match expectation (-expected +actual):\n%s",
+		u.GetKind(), utils.NamespaceOrDefault(u), u.GetName(), diff)}
+}
@@ -0,0 +1,80 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDiffExpectation(t *testing.T) {
+	parse := func(data string) interface{} {
+		var val interface{}
+		if err := yaml.Unmarshal([]byte(data), &val); err != nil {
+			t.Fatalf("failed to parse %q: %s", data, err)
+		}
+
+		return val
+	}
+
+	actual := parse(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: httpbin
+  labels:
+    app.kubernetes.io/name: httpbin
+    app.kubernetes.io/managed-by: modden
+spec:
+  replicas: 1
+status:
+  readyReplicas: 1
+  conditions:
+  - type: Progressing
+    status: "True"
+  - type: Available
+    status: "True"
+`)
+
+	// Subsets match.
+	assert.Empty(t, diffExpectation(parse(`
+metadata:
+  name: httpbin
+status:
+  readyReplicas: 1
+`), actual))
+
+	// Array elements match in any order.
+	assert.Empty(t, diffExpectation(parse(`
+status:
+  conditions:
+  - type: Available
+    status: "True"
+`), actual))
+
+	// Mismatched fields are reported.
+	assert.NotEmpty(t, diffExpectation(parse(`
+spec:
+  replicas: 2
+`), actual))
+
+	// Missing fields are reported.
+	assert.NotEmpty(t, diffExpectation(parse(`
+spec:
+  paused: true
+`), actual))
+
+	// Unmatched array elements are reported.
+	assert.NotEmpty(t, diffExpectation(parse(`
+status:
+  conditions:
+  - type: Available
+    status: "False"
+`), actual))
+
+	// Mismatched types are reported.
+	assert.NotEmpty(t, diffExpectation(parse(`
+metadata:
+  labels: foo
+`), actual))
+}
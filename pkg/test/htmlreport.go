@@ -0,0 +1,270 @@
+package test
+
+import (
+	"html/template"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+)
+
+// maxEmbeddedArtifact is the maximum number of bytes of an artifact
+// file that is embedded in an HTML report.
+const maxEmbeddedArtifact = 256 * 1024
+
+type htmlArtifact struct {
+	Path      string
+	Content   string
+	Truncated bool
+	Err       string
+}
+
+type htmlResult struct {
+	Severity string
+	Message  string
+}
+
+type htmlStep struct {
+	Description string
+	Status      string
+	Duration    string
+	Results     []htmlResult
+	Diagnostics []string
+	Artifacts   []htmlArtifact
+}
+
+type htmlDocument struct {
+	Description string
+	Status      string
+	Duration    string
+	Properties  []string
+	Steps       []htmlStep
+}
+
+type htmlReport struct {
+	Generated  string
+	Documents  []htmlDocument
+	Counts     map[string]int
+	Severities []string
+}
+
+// stepStatus returns "fail", "skip" or "pass" for the results of a step.
+func stepStatus(results []result.Result) string {
+	status := "pass"
+
+	for _, r := range results {
+		switch {
+		case r.IsFailed():
+			return "fail"
+		case r.Severity == result.SeveritySkip:
+			status = "skip"
+		}
+	}
+
+	return status
+}
+
+// formatDuration formats the time between start and end, or returns
+// an empty string if either time is unknown.
+func formatDuration(start time.Time, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return ""
+	}
+
+	return end.Sub(start).Round(time.Millisecond).String()
+}
+
+// readArtifact reads an artifact file for embedding in the report.
+func readArtifact(path string) htmlArtifact {
+	a := htmlArtifact{Path: path}
+
+	f, err := os.Open(path)
+	if err != nil {
+		a.Err = err.Error()
+		return a
+	}
+
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(f, maxEmbeddedArtifact+1))
+	if err != nil {
+		a.Err = err.Error()
+		return a
+	}
+
+	if len(data) > maxEmbeddedArtifact {
+		data = data[:maxEmbeddedArtifact]
+		a.Truncated = true
+	}
+
+	a.Content = string(data)
+	return a
+}
+
+func newHTMLStep(s *Step) htmlStep {
+	h := htmlStep{
+		Description: s.Description,
+		Status:      stepStatus(s.Results),
+		Duration:    formatDuration(s.Start, s.End),
+	}
+
+	for _, r := range s.Results {
+		h.Results = append(h.Results, htmlResult{
+			Severity: string(r.Severity),
+			Message:  r.Message,
+		})
+	}
+
+	// Artifacts are embedded, so don't also list them as
+	// diagnostics.
+	diags := map[string]interface{}{}
+	for k, v := range s.Diagnostics {
+		if k == DiagnosticArtifacts {
+			if paths, ok := v.([]string); ok {
+				for _, p := range paths {
+					h.Artifacts = append(h.Artifacts, readArtifact(p))
+				}
+				continue
+			}
+		}
+
+		diags[k] = v
+	}
+
+	h.Diagnostics = formatDiagnostics(diags)
+
+	return h
+}
+
+func newHTMLDocument(d *Document) htmlDocument {
+	h := htmlDocument{
+		Description: d.Description,
+		Status:      "pass",
+		Properties:  formatProperties(d.Properties),
+	}
+
+	var start time.Time
+	var end time.Time
+
+	for _, s := range d.Steps {
+		step := newHTMLStep(s)
+
+		switch {
+		case step.Status == "fail":
+			h.Status = "fail"
+		case step.Status == "skip" && h.Status == "pass":
+			h.Status = "skip"
+		}
+
+		if start.IsZero() || (!s.Start.IsZero() && s.Start.Before(start)) {
+			start = s.Start
+		}
+
+		if s.End.After(end) {
+			end = s.End
+		}
+
+		h.Steps = append(h.Steps, step)
+	}
+
+	h.Duration = formatDuration(start, end)
+
+	return h
+}
+
+// WriteHTMLReport writes the given test documents as a single,
+// self-contained HTML page. Documents and steps are collapsible, and
+// results can be filtered by severity. Any artifacts that were
+// recorded in step diagnostics are embedded in the report.
+func WriteHTMLReport(w io.Writer, docs []*Document) error {
+	report := htmlReport{
+		Generated: time.Now().Format(time.RFC1123),
+		Counts:    map[string]int{},
+		Severities: []string{
+			string(result.SeverityNone),
+			string(result.SeverityError),
+			string(result.SeverityFatal),
+			string(result.SeveritySkip),
+		},
+	}
+
+	for _, d := range docs {
+		h := newHTMLDocument(d)
+		report.Counts[h.Status]++
+		report.Documents = append(report.Documents, h)
+	}
+
+	return htmlReportTemplate.Execute(w, report)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"lower": strings.ToLower,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>modden test report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+summary { cursor: pointer; padding: 0.2em; }
+details { margin-left: 1em; }
+pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }
+.duration { color: #6a737d; font-size: smaller; }
+.status { font-weight: bold; text-transform: uppercase; }
+.pass > summary .status { color: #22863a; }
+.fail > summary .status { color: #cb2431; }
+.skip > summary .status { color: #b08800; }
+.result { margin: 0.1em 0 0.1em 2em; font-family: monospace; white-space: pre-wrap; }
+.sev-error, .sev-fatal { color: #cb2431; }
+.sev-skip { color: #b08800; }
+.artifact-error { color: #cb2431; font-family: monospace; }
+.diagnostic { margin-left: 2em; font-family: monospace; color: #6a737d; }
+.hidden { display: none; }
+#filters { margin-bottom: 1em; }
+</style>
+</head>
+<body>
+<h1>modden test report</h1>
+<p>Generated {{.Generated}}: {{index .Counts "pass"}} passed, {{index .Counts "fail"}} failed, {{index .Counts "skip"}} skipped.</p>
+<div id="filters">
+Show results:
+{{range .Severities}}<label><input type="checkbox" data-severity="{{lower .}}" checked> {{.}}</label>
+{{end}}
+</div>
+{{range .Documents}}
+<details class="document {{.Status}}"{{if eq .Status "fail"}} open{{end}}>
+<summary><span class="status">{{.Status}}</span> {{.Description}} <span class="duration">{{.Duration}}</span></summary>
+{{range .Properties}}<div class="diagnostic">{{.}}</div>
+{{end}}
+{{range $n, $s := .Steps}}
+<details class="step {{.Status}}"{{if eq .Status "fail"}} open{{end}}>
+<summary><span class="status">{{.Status}}</span> Step {{$n}}: {{.Description}} <span class="duration">{{.Duration}}</span></summary>
+{{range .Results}}<div class="result sev-{{lower .Severity}}">{{if ne .Severity "None"}}{{.Severity}}: {{end}}{{.Message}}</div>
+{{end}}
+{{range .Diagnostics}}<div class="diagnostic">{{.}}</div>
+{{end}}
+{{range .Artifacts}}
+<details class="artifact">
+<summary>{{.Path}}{{if .Truncated}} (truncated){{end}}</summary>
+{{if .Err}}<div class="artifact-error">{{.Err}}</div>{{else}}<pre>{{.Content}}</pre>{{end}}
+</details>
+{{end}}
+</details>
+{{end}}
+</details>
+{{end}}
+<script>
+document.querySelectorAll("#filters input").forEach(function(box) {
+  box.addEventListener("change", function() {
+    document.querySelectorAll(".sev-" + box.dataset.severity).forEach(function(e) {
+      e.classList.toggle("hidden", !box.checked);
+    });
+  });
+});
+</script>
+</body>
+</html>
+`))
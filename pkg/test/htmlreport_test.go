@@ -0,0 +1,77 @@
+package test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteHTMLReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	artifact := filepath.Join(dir, "objects.yaml")
+	require.NoError(t, ioutil.WriteFile(artifact, []byte("kind: <Service>"), 0644))
+
+	start := time.Now()
+
+	docs := []*Document{{
+		Description: "passing.yaml",
+		Properties:  map[string]interface{}{"name": "passing"},
+		Steps: []*Step{{
+			Description: "applying object",
+			Start:       start,
+			End:         start.Add(1500 * time.Millisecond),
+			Results:     []result.Result{result.Infof("created Service")},
+		}},
+	}, {
+		Description: "failing.yaml",
+		Properties:  map[string]interface{}{},
+		Steps: []*Step{{
+			Description: "running object update check",
+			Start:       start,
+			End:         start.Add(time.Second),
+			Results:     []result.Result{result.Errorf("check <failed>")},
+			Diagnostics: map[string]interface{}{
+				DiagnosticEvents:    []string{"Warning BackOff pod/httpbin: back-off"},
+				DiagnosticArtifacts: []string{artifact, filepath.Join(dir, "missing.log")},
+			},
+		}},
+	}}
+
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteHTMLReport(&buf, docs))
+
+	html := buf.String()
+
+	assert.Contains(t, html, "1 passed, 1 failed, 0 skipped")
+	assert.Contains(t, html, `<details class="document fail" open>`)
+	assert.Contains(t, html, `<details class="document pass">`)
+	assert.Contains(t, html, "1.5s")
+	assert.Contains(t, html, `<div class="result sev-none">created Service</div>`)
+	assert.Contains(t, html, `<div class="result sev-error">Error: check &lt;failed&gt;</div>`)
+	assert.Contains(t, html, "events: Warning BackOff pod/httpbin: back-off")
+
+	// Artifacts are embedded and escaped, rather than listed
+	// as diagnostics.
+	assert.Contains(t, html, "<pre>kind: &lt;Service&gt;</pre>")
+	assert.Contains(t, html, `<div class="artifact-error">`)
+	assert.False(t, strings.Contains(html, "artifacts: "))
+}
+
+func TestStepStatus(t *testing.T) {
+	assert.Equal(t, "pass", stepStatus(nil))
+	assert.Equal(t, "pass", stepStatus([]result.Result{result.Infof("info")}))
+	assert.Equal(t, "skip", stepStatus([]result.Result{result.Skipf("skip")}))
+	assert.Equal(t, "fail", stepStatus([]result.Result{result.Skipf("skip"), result.Fatalf("fatal")}))
+}
@@ -0,0 +1,173 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jpeach/modden/pkg/must"
+)
+
+// JSONWriter is a Recorder that writes test records as JSON. Each
+// test document is written as a single line containing a JSON
+// Document object when the document is closed.
+type JSONWriter struct {
+	records defaultRecorder
+	encoder *json.Encoder
+}
+
+var _ Recorder = &JSONWriter{}
+
+// NewJSONWriter returns a JSONWriter that writes to w.
+func NewJSONWriter(w io.Writer) *JSONWriter {
+	return &JSONWriter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Failed ...
+func (j *JSONWriter) Failed() bool {
+	return false
+}
+
+// NewDocument ...
+func (j *JSONWriter) NewDocument(desc string) DocumentHandle {
+	d := j.records.NewDocument(desc).(*defaultDocument)
+
+	return &jsonDocument{
+		DocumentHandle: d,
+		writer:         j,
+		doc:            d.doc,
+	}
+}
+
+// jsonDocument is the DocumentHandle for a JSONWriter document.
+type jsonDocument struct {
+	DocumentHandle
+
+	writer *JSONWriter
+	doc    *Document
+}
+
+// Close writes the document.
+func (d *jsonDocument) Close() {
+	d.DocumentHandle.Close()
+
+	j := d.writer
+
+	j.records.lock.Lock()
+	must.Must(j.encoder.Encode(d.doc))
+	j.records.lock.Unlock()
+
+	// We don't need to keep documents that have
+	// been written.
+	j.records.forget(d.doc)
+}
+
+// WriteJSON writes the given test documents in the same format as
+// a JSONWriter.
+func WriteJSON(w io.Writer, docs []*Document) error {
+	encoder := json.NewEncoder(w)
+
+	for _, d := range docs {
+		if err := encoder.Encode(d); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReadJSON reads the test documents that were written by a
+// JSONWriter.
+func ReadJSON(r io.Reader) ([]*Document, error) {
+	var docs []*Document
+
+	decoder := json.NewDecoder(r)
+	for decoder.More() {
+		doc := &Document{}
+		if err := decoder.Decode(doc); err != nil {
+			return nil, fmt.Errorf("failed to decode test document %d: %w", len(docs)+1, err)
+		}
+
+		// JSON decodes string slices as generic slices and
+		// structs as generic maps, so restore the types that
+		// diagnostics are recorded with.
+		for _, s := range doc.Steps {
+			for k, v := range s.Diagnostics {
+				if strings, ok := stringSlice(v); ok {
+					s.Diagnostics[k] = strings
+				}
+			}
+
+			if v, ok := s.Diagnostics[DiagnosticCheck]; ok {
+				if stats, ok := checkStatsFrom(v); ok {
+					s.Diagnostics[DiagnosticCheck] = stats
+				}
+			}
+		}
+
+		if doc.Properties == nil {
+			doc.Properties = map[string]interface{}{}
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// stringSlice converts a generic slice to a string slice, if all
+// of its elements are strings.
+func stringSlice(v interface{}) ([]string, bool) {
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	strings := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, false
+		}
+
+		strings = append(strings, s)
+	}
+
+	return strings, true
+}
+
+// Replay records the given test documents with the Recorder, as
+// if the tests were being run again.
+func Replay(r Recorder, docs []*Document) {
+	for _, d := range docs {
+		doc := r.NewDocument(d.Description)
+
+		if len(d.Properties) > 0 {
+			doc.SetProperties(d.Properties)
+		}
+
+		for _, s := range d.Steps {
+			h := doc.NewStep(s.Description)
+
+			// Keep the original step times, if the
+			// Recorder can record them.
+			if t, ok := h.(TimedStepHandle); ok && !s.Start.IsZero() {
+				t.SetTimes(s.Start, s.End)
+			}
+
+			if len(s.Results) > 0 {
+				h.Update(s.Results...)
+			}
+
+			for k, v := range s.Diagnostics {
+				h.Diagnose(k, v)
+			}
+
+			h.Close()
+		}
+
+		doc.Close()
+	}
+}
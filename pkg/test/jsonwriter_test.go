@@ -0,0 +1,93 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONWriterRoundTrip(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := NewJSONWriter(&buf)
+
+	for _, name := range []string{"first.yaml", "second.yaml"} {
+		d := w.NewDocument(name)
+		d.SetProperties(map[string]interface{}{"name": name})
+
+		s := d.NewStep("running check")
+		s.Update(result.Infof("checking"), result.Errorf("failed"))
+		s.Diagnose(DiagnosticEvents, []string{"Warning BackOff pod/httpbin: back-off"})
+		s.Close()
+
+		d.Close()
+	}
+
+	// Each document is written on its own line.
+	assert.Len(t, strings.Split(strings.TrimSpace(buf.String()), "\n"), 2)
+
+	docs, err := ReadJSON(&buf)
+	require.NoError(t, err)
+	require.Len(t, docs, 2)
+
+	d := docs[1]
+	assert.Equal(t, "second.yaml", d.Description)
+	assert.Equal(t, "second.yaml", d.Properties["name"])
+	require.Len(t, d.Steps, 1)
+
+	s := d.Steps[0]
+	assert.Equal(t, "running check", s.Description)
+	assert.False(t, s.Start.IsZero())
+	assert.False(t, s.End.Before(s.Start))
+	require.Len(t, s.Results, 2)
+	assert.Equal(t, result.SeverityError, s.Results[1].Severity)
+	assert.Equal(t, "failed", s.Results[1].Message)
+	assert.False(t, s.Results[1].Timestamp.IsZero())
+
+	// String slice diagnostics survive the round trip.
+	assert.Equal(t, []string{"Warning BackOff pod/httpbin: back-off"}, s.Diagnostics[DiagnosticEvents])
+}
+
+func TestReadJSONError(t *testing.T) {
+	_, err := ReadJSON(strings.NewReader(`{"description": "ok", "steps": []}` + "\n{garbage"))
+	assert.Error(t, err)
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	docs := []*Document{{
+		Description: "test.yaml",
+		Properties:  map[string]interface{}{"name": "test"},
+		Steps: []*Step{{
+			Description: "running check",
+			Start:       start,
+			End:         start.Add(1500 * time.Millisecond),
+			Results:     []result.Result{result.Fatalf("fatal")},
+			Diagnostics: map[string]interface{}{DiagnosticEvents: []string{"event"}},
+		}},
+	}}
+
+	r := &defaultRecorder{}
+	Replay(r, docs)
+
+	require.Len(t, r.docs, 1)
+	assert.Equal(t, "test.yaml", r.docs[0].Description)
+	assert.Equal(t, "test", r.docs[0].Properties["name"])
+	require.Len(t, r.docs[0].Steps, 1)
+	assert.Equal(t, docs[0].Steps[0].Results, r.docs[0].Steps[0].Results)
+	assert.Equal(t, docs[0].Steps[0].Diagnostics, r.docs[0].Steps[0].Diagnostics)
+	assert.Equal(t, docs[0].Steps[0].Start, r.docs[0].Steps[0].Start)
+	assert.Equal(t, docs[0].Steps[0].End, r.docs[0].Steps[0].End)
+	assert.True(t, r.Failed())
+
+	// Replayed steps show their recorded duration.
+	out := &bytes.Buffer{}
+	Replay(&TreeWriter{out: out}, docs)
+	assert.Contains(t, out.String(), "Failed with 1 error (1.5s)")
+}
@@ -0,0 +1,188 @@
+package test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+)
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Skipped    int             `xml:"skipped,attr"`
+	Time       string          `xml:"time,attr"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	TestCases  []junitTestCase `xml:"testcase"`
+}
+
+type junitReport struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+// junitTime formats the time between start and end in seconds, or
+// returns zero if either time is unknown.
+func junitTime(start time.Time, end time.Time) string {
+	if start.IsZero() || end.IsZero() {
+		return "0.000"
+	}
+
+	return fmt.Sprintf("%.3f", end.Sub(start).Seconds())
+}
+
+func newJUnitTestCase(d *Document, s *Step) junitTestCase {
+	c := junitTestCase{
+		Name:      s.Description,
+		Classname: d.Description,
+		Time:      junitTime(s.Start, s.End),
+	}
+
+	var output []string
+	var failures []string
+
+	for _, r := range s.Results {
+		switch {
+		case r.IsFailed():
+			failures = append(failures, r.Message)
+			if c.Failure == nil {
+				c.Failure = &junitFailure{
+					Message: r.Message,
+					Type:    string(r.Severity),
+				}
+			}
+		case r.Severity == result.SeveritySkip:
+			if c.Skipped == nil {
+				c.Skipped = &junitSkipped{Message: r.Message}
+			}
+		default:
+			output = append(output, r.Message)
+		}
+	}
+
+	if c.Failure != nil {
+		c.Failure.Text = strings.Join(failures, "\n")
+		c.Skipped = nil
+	}
+
+	output = append(output, formatDiagnostics(s.Diagnostics)...)
+	c.SystemOut = strings.Join(output, "\n")
+
+	return c
+}
+
+func newJUnitTestSuite(d *Document) junitTestSuite {
+	suite := junitTestSuite{
+		Name: d.Description,
+	}
+
+	keys := make([]string, 0, len(d.Properties))
+	for k := range d.Properties {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		suite.Properties = append(suite.Properties, junitProperty{
+			Name:  k,
+			Value: formatPropertyValue(d.Properties[k]),
+		})
+	}
+
+	var start time.Time
+	var end time.Time
+
+	for _, s := range d.Steps {
+		c := newJUnitTestCase(d, s)
+
+		suite.Tests++
+		switch {
+		case c.Failure != nil:
+			suite.Failures++
+		case c.Skipped != nil:
+			suite.Skipped++
+		}
+
+		if start.IsZero() || (!s.Start.IsZero() && s.Start.Before(start)) {
+			start = s.Start
+		}
+
+		if s.End.After(end) {
+			end = s.End
+		}
+
+		suite.TestCases = append(suite.TestCases, c)
+	}
+
+	suite.Time = junitTime(start, end)
+	if !start.IsZero() {
+		suite.Timestamp = start.Format("2006-01-02T15:04:05")
+	}
+
+	return suite
+}
+
+// WriteJUnitReport writes the given test documents as a JUnit XML
+// report. Each document is a test suite, and each of its steps is a
+// test case. The document properties are written as test suite
+// properties.
+func WriteJUnitReport(w io.Writer, docs []*Document) error {
+	report := junitReport{}
+
+	for _, d := range docs {
+		suite := newJUnitTestSuite(d)
+
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, suite)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,92 @@
+package test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteJUnitReport(t *testing.T) {
+	start := time.Date(2020, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+	docs := []*Document{{
+		Description: "passing.yaml",
+		Properties: map[string]interface{}{
+			"name": "passing",
+			"tags": []interface{}{"smoke", "httpproxy"},
+		},
+		Steps: []*Step{{
+			Description: "applying object",
+			Start:       start,
+			End:         start.Add(1500 * time.Millisecond),
+			Results:     []result.Result{result.Infof("created Service")},
+		}, {
+			Description: "skipping check",
+			Results:     []result.Result{result.Skipf("no cluster")},
+		}},
+	}, {
+		Description: "failing.yaml",
+		Properties:  map[string]interface{}{},
+		Steps: []*Step{{
+			Description: "running object update check",
+			Start:       start,
+			End:         start.Add(time.Second),
+			Results: []result.Result{
+				result.Errorf("check <failed>"),
+				result.Fatalf("gave up"),
+			},
+			Diagnostics: map[string]interface{}{
+				DiagnosticEvents: []string{"Warning BackOff pod/httpbin: back-off"},
+			},
+		}},
+	}}
+
+	buf := bytes.Buffer{}
+	require.NoError(t, WriteJUnitReport(&buf, docs))
+
+	assert.Contains(t, buf.String(), xml.Header)
+	assert.Contains(t, buf.String(), "check &lt;failed&gt;")
+
+	report := junitReport{}
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &report))
+
+	assert.Equal(t, 3, report.Tests)
+	assert.Equal(t, 1, report.Failures)
+	assert.Equal(t, 1, report.Skipped)
+	require.Len(t, report.Suites, 2)
+
+	passing := report.Suites[0]
+	assert.Equal(t, "passing.yaml", passing.Name)
+	assert.Equal(t, "1.500", passing.Time)
+	assert.Equal(t, "2020-05-01T12:00:00", passing.Timestamp)
+	assert.Equal(t, []junitProperty{
+		{Name: "name", Value: "passing"},
+		{Name: "tags", Value: "smoke, httpproxy"},
+	}, passing.Properties)
+
+	require.Len(t, passing.TestCases, 2)
+	assert.Equal(t, "applying object", passing.TestCases[0].Name)
+	assert.Equal(t, "passing.yaml", passing.TestCases[0].Classname)
+	assert.Equal(t, "created Service", passing.TestCases[0].SystemOut)
+	assert.Nil(t, passing.TestCases[0].Failure)
+	require.NotNil(t, passing.TestCases[1].Skipped)
+	assert.Equal(t, "no cluster", passing.TestCases[1].Skipped.Message)
+	assert.Equal(t, "0.000", passing.TestCases[1].Time)
+
+	failing := report.Suites[1]
+	assert.Empty(t, failing.Properties)
+	require.Len(t, failing.TestCases, 1)
+
+	c := failing.TestCases[0]
+	require.NotNil(t, c.Failure)
+	assert.Equal(t, "check <failed>", c.Failure.Message)
+	assert.Equal(t, string(result.SeverityError), c.Failure.Type)
+	assert.Equal(t, "check <failed>\ngave up", c.Failure.Text)
+	assert.Equal(t, "events: Warning BackOff pod/httpbin: back-off", c.SystemOut)
+}
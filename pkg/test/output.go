@@ -0,0 +1,59 @@
+package test
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/jpeach/modden/pkg/must"
+)
+
+// documentOutput is the output of a single test document.
+type documentOutput struct {
+	buf    bytes.Buffer
+	closed bool
+}
+
+// serialOutput serializes the output of test documents that are
+// recorded at the same time, so that the output of each document
+// is contiguous. The output of the oldest open document is written
+// immediately, and the output of the others is buffered until all
+// the documents that were opened before them are closed. The caller
+// must serialize access to a serialOutput.
+type serialOutput struct {
+	docs []*documentOutput
+}
+
+// open starts the output of a new document.
+func (s *serialOutput) open() *documentOutput {
+	d := &documentOutput{}
+	s.docs = append(s.docs, d)
+	return d
+}
+
+// writer returns the writer for the output of the document.
+func (s *serialOutput) writer(out io.Writer, d *documentOutput) io.Writer {
+	if len(s.docs) > 0 && s.docs[0] == d {
+		return out
+	}
+
+	return &d.buf
+}
+
+// close ends the output of the document, and writes any output
+// that was buffered by the documents that were waiting for it.
+func (s *serialOutput) close(out io.Writer, d *documentOutput) {
+	d.closed = true
+
+	for len(s.docs) > 0 {
+		first := s.docs[0]
+
+		_, err := first.buf.WriteTo(out)
+		must.Must(err)
+
+		if !first.closed {
+			return
+		}
+
+		s.docs = s.docs[1:]
+	}
+}
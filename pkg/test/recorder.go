@@ -2,17 +2,19 @@ package test
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/result"
 )
 
 // Document records the execution of a test document.
 type Document struct {
-	Description string
-	Properties  map[string]interface{}
-	Steps       []*Step
+	Description string                 `json:"description"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Steps       []*Step                `json:"steps"`
 }
 
 // EachResult walks the test document and applies the function to
@@ -25,14 +27,74 @@ func (d *Document) EachResult(f func(*Step, *result.Result)) {
 	}
 }
 
+// formatProperties formats document properties as "key: value"
+// strings, ordered by key.
+func formatProperties(props map[string]interface{}) []string {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("%s: %s", k, formatPropertyValue(props[k])))
+	}
+
+	return lines
+}
+
+// formatPropertyValue formats a document property value. The
+// elements of a slice value are separated by commas.
+func formatPropertyValue(value interface{}) string {
+	switch v := value.(type) {
+	case []interface{}:
+		values := make([]string, len(v))
+		for i := range v {
+			values[i] = fmt.Sprint(v[i])
+		}
+
+		return strings.Join(values, ", ")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatDiagnostics formats step diagnostics as "key: value"
+// strings, ordered by key. Each element of a string slice value is
+// formatted on a separate line.
+func formatDiagnostics(diags map[string]interface{}) []string {
+	keys := make([]string, 0, len(diags))
+	for k := range diags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var lines []string
+	for _, k := range keys {
+		switch v := diags[k].(type) {
+		case []string:
+			for _, s := range v {
+				lines = append(lines, fmt.Sprintf("%s: %s", k, s))
+			}
+		default:
+			lines = append(lines, fmt.Sprintf("%s: %v", k, v))
+		}
+	}
+
+	return lines
+}
+
 // Step describes a stage in a test document that can generate onr
 // or more related errors.
 type Step struct {
-	Description string
-	Start       time.Time
-	End         time.Time
-	Results     []result.Result
-	Diagnostics map[string]interface{}
+	Description string                 `json:"description"`
+	Start       time.Time              `json:"start"`
+	End         time.Time              `json:"end"`
+	Results     []result.Result        `json:"results,omitempty"`
+	Diagnostics map[string]interface{} `json:"diagnostics,omitempty"`
 }
 
 // Closer is an interface that closes an implicit test tracking entity.
@@ -50,62 +112,134 @@ func (c CloserFunc) Close() {
 	}
 }
 
-// Recorder is an object that records structured test information.
-type Recorder interface {
+// StepHandle records the results of a single test step. Results
+// and diagnostics recorded with a StepHandle are always attached to
+// its step, even if other steps are open at the same time. Closing
+// the StepHandle closes the step.
+type StepHandle interface {
+	Closer
+
+	// Update records results for the step.
+	Update(...result.Result)
+
+	// Diagnose records diagnostic information about the step.
+	// Diagnostics help to explain why a step failed, so
+	// recorders may only show them for failing steps.
+	Diagnose(key string, value interface{})
+}
+
+// TimedStepHandle is a StepHandle that can record when its step
+// started and ended, instead of using the time that the step was
+// created and closed. This is used to replay recorded steps.
+type TimedStepHandle interface {
+	StepHandle
+
+	// SetTimes records the start and end times of the step.
+	// The end time is kept when the step is closed.
+	SetTimes(start time.Time, end time.Time)
+}
+
+// DocumentHandle records the steps of a single test document. Steps
+// created with a DocumentHandle are always attached to its document,
+// even if other documents are open at the same time. Closing the
+// DocumentHandle closes the document.
+type DocumentHandle interface {
+	Closer
+
 	// ShouldContinue returns whether a test harness should
-	// continue to run tests. Typically, this will return false
-	// if a fatal test error has been reported.
+	// continue to run the document. Typically, this will return
+	// false if a fatal test error has been reported.
 	ShouldContinue() bool
 
-	// Failed returns true if any errors have been reported.
-	Failed() bool
+	// NewStep creates a new step in the test document. The
+	// step is closed by closing the returned StepHandle.
+	NewStep(desc string) StepHandle
 
-	// NewDocument created a new test document that can be
-	// closed by calling the returned Closer.
-	NewDocument(desc string) Closer
+	// SetProperties records descriptive properties of the
+	// test document.
+	SetProperties(map[string]interface{})
+}
 
-	// NewDocument created a new test document that can be
-	// closed by calling the returned Closer.
-	NewStep(desc string) Closer
+// Recorder is an object that records structured test information.
+// Recorders are safe for concurrent use, and can record many test
+// documents at the same time.
+type Recorder interface {
+	// Failed returns true if any errors have been reported.
+	Failed() bool
 
-	Update(...result.Result)
+	// NewDocument creates a new test document that can be
+	// closed by closing the returned DocumentHandle.
+	NewDocument(desc string) DocumentHandle
 }
 
 type defaultRecorder struct {
+	lock sync.Mutex
 	docs []*Document
-
-	currentDoc  *Document
-	currentStep *Step
 }
 
+var _ Recorder = &defaultRecorder{}
+
 // DefaultRecorder ...
 var DefaultRecorder Recorder = &defaultRecorder{}
 
-// ShouldContinue returns false if any fatal errors have been recorded.
-func (r *defaultRecorder) ShouldContinue() bool {
-	terminal := false
+// Documents returns a copy of the test documents that have been
+// recorded by the DefaultRecorder.
+func Documents() []*Document {
+	if r, ok := DefaultRecorder.(*defaultRecorder); ok {
+		return r.documents()
+	}
 
-	// Make the check context-dependent. If we are in the middle
-	// of a doc, this asks whether we should keep going on the
-	// doc, otherwise it asks whether we should keep going at all.
-	which := r.docs
-	if r.currentDoc != nil {
-		which = []*Document{r.currentDoc}
+	return nil
+}
+
+// documents returns a copy of the recorded documents, so that the
+// caller can inspect them while steps are still being recorded.
+func (r *defaultRecorder) documents() []*Document {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	docs := make([]*Document, 0, len(r.docs))
+	for _, d := range r.docs {
+		docs = append(docs, d.copy())
 	}
 
-	for _, d := range which {
-		d.EachResult(func(s *Step, r *result.Result) {
-			if r.IsTerminal() {
-				terminal = true
+	return docs
+}
+
+// copy returns a copy of the document and its steps.
+func (d *Document) copy() *Document {
+	c := &Document{
+		Description: d.Description,
+		Properties:  make(map[string]interface{}, len(d.Properties)),
+		Steps:       make([]*Step, 0, len(d.Steps)),
+	}
+
+	for k, v := range d.Properties {
+		c.Properties[k] = v
+	}
+
+	for _, s := range d.Steps {
+		step := *s
+		step.Results = append([]result.Result(nil), s.Results...)
+
+		if s.Diagnostics != nil {
+			step.Diagnostics = make(map[string]interface{}, len(s.Diagnostics))
+			for k, v := range s.Diagnostics {
+				step.Diagnostics[k] = v
 			}
-		})
+		}
+
+		c.Steps = append(c.Steps, &step)
 	}
 
-	return !terminal
+	return c
 }
 
 // Failed returns true if any errors have been recorded.
 func (r *defaultRecorder) Failed() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
 	failed := false
 
 	for _, d := range r.docs {
@@ -119,51 +253,179 @@ func (r *defaultRecorder) Failed() bool {
 	return failed
 }
 
-// NewDocument creates a new Document and makes it current.
-func (r *defaultRecorder) NewDocument(desc string) Closer {
-	must.Check(r.currentStep == nil,
-		fmt.Errorf("can't create a new doc with an open step"))
+// NewDocument creates a new Document.
+func (r *defaultRecorder) NewDocument(desc string) DocumentHandle {
+	r.lock.Lock()
+	defer r.lock.Unlock()
 
-	doc := &Document{}
+	doc := &Document{
+		Description: desc,
+		Properties:  map[string]interface{}{},
+	}
 
-	r.currentDoc = doc
 	r.docs = append(r.docs, doc)
 
-	return CloserFunc(func() {
-		must.Check(r.currentDoc == doc,
-			fmt.Errorf("overlapping docs"))
-		must.Check(r.currentStep == nil,
-			fmt.Errorf("closing doc with open step"))
+	return &defaultDocument{recorder: r, doc: doc}
+}
+
+// forget removes a document from the recorder.
+func (r *defaultRecorder) forget(doc *Document) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for i, d := range r.docs {
+		if d == doc {
+			r.docs = append(r.docs[:i], r.docs[i+1:]...)
+			return
+		}
+	}
+}
+
+// defaultDocument is the DocumentHandle for a defaultRecorder Document.
+type defaultDocument struct {
+	recorder *defaultRecorder
+	doc      *Document
+}
+
+var _ DocumentHandle = &defaultDocument{}
 
-		r.currentDoc = nil
+// Close does nothing, since the Document is complete once all its
+// steps are closed.
+func (d *defaultDocument) Close() {
+}
+
+// ShouldContinue returns false if any fatal errors have been
+// recorded in the document.
+func (d *defaultDocument) ShouldContinue() bool {
+	d.recorder.lock.Lock()
+	defer d.recorder.lock.Unlock()
+
+	terminal := false
+
+	d.doc.EachResult(func(s *Step, r *result.Result) {
+		if r.IsTerminal() {
+			terminal = true
+		}
 	})
+
+	return !terminal
 }
 
-// NewStep creates a new Step within the current Document and makes
-// that the current Step.
-func (r *defaultRecorder) NewStep(desc string) Closer {
-	must.Check(r.currentDoc != nil,
-		fmt.Errorf("no open document"))
+// NewStep creates a new Step within the Document.
+func (d *defaultDocument) NewStep(desc string) StepHandle {
+	d.recorder.lock.Lock()
+	defer d.recorder.lock.Unlock()
 
 	step := &Step{
 		Description: desc,
 		Start:       time.Now(),
 	}
 
-	r.currentStep = step
-	r.currentDoc.Steps = append(r.currentDoc.Steps, step)
+	d.doc.Steps = append(d.doc.Steps, step)
 
-	return CloserFunc(func() {
-		must.Check(r.currentStep == step,
-			fmt.Errorf("overlapping steps"))
+	return &defaultStep{recorder: d.recorder, step: step}
+}
 
-		step.End = time.Now()
+// SetProperties merges the given properties into the Document.
+func (d *defaultDocument) SetProperties(props map[string]interface{}) {
+	d.recorder.lock.Lock()
+	defer d.recorder.lock.Unlock()
 
-		r.currentStep = nil
-	})
+	for k, v := range props {
+		d.doc.Properties[k] = v
+	}
+}
+
+// defaultStep is the StepHandle for a defaultRecorder Step.
+type defaultStep struct {
+	recorder *defaultRecorder
+	step     *Step
+	timed    bool
+	closed   bool
+}
+
+var _ TimedStepHandle = &defaultStep{}
+
+// Close ends the step.
+func (s *defaultStep) Close() {
+	s.recorder.lock.Lock()
+	defer s.recorder.lock.Unlock()
+
+	if !s.closed && !s.timed {
+		s.step.End = time.Now()
+	}
+
+	s.closed = true
 }
 
-func (r *defaultRecorder) Update(res ...result.Result) {
-	must.Check(r.currentStep != nil, fmt.Errorf("no open step"))
-	r.currentStep.Results = append(r.currentStep.Results, res...)
+// SetTimes sets the start and end times of the step.
+func (s *defaultStep) SetTimes(start time.Time, end time.Time) {
+	s.recorder.lock.Lock()
+	defer s.recorder.lock.Unlock()
+
+	s.step.Start = start
+	s.step.End = end
+	s.timed = true
 }
+
+// Update appends the results to the step.
+func (s *defaultStep) Update(res ...result.Result) {
+	s.recorder.lock.Lock()
+	defer s.recorder.lock.Unlock()
+
+	s.step.Results = append(s.step.Results, res...)
+}
+
+// Diagnose sets the diagnostic value for the key in the step.
+func (s *defaultStep) Diagnose(key string, value interface{}) {
+	s.recorder.lock.Lock()
+	defer s.recorder.lock.Unlock()
+
+	if s.step.Diagnostics == nil {
+		s.step.Diagnostics = map[string]interface{}{}
+	}
+
+	s.step.Diagnostics[key] = value
+}
+
+// failureDocument is a DocumentHandle that only tracks whether any
+// failures have been reported. It can be stacked with another
+// DocumentHandle to track failures in a single document.
+type failureDocument struct {
+	lock   sync.Mutex
+	failed bool
+}
+
+var _ DocumentHandle = &failureDocument{}
+
+func (f *failureDocument) Close()                               {}
+func (f *failureDocument) ShouldContinue() bool                 { return true }
+func (f *failureDocument) NewStep(string) StepHandle            { return &failureStep{f} }
+func (f *failureDocument) SetProperties(map[string]interface{}) {}
+
+func (f *failureDocument) Failed() bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.failed
+}
+
+func (f *failureDocument) update(results ...result.Result) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, r := range results {
+		if r.IsFailed() {
+			f.failed = true
+		}
+	}
+}
+
+// failureStep is the StepHandle for a failureDocument.
+type failureStep struct {
+	doc *failureDocument
+}
+
+func (f *failureStep) Close()                                 {}
+func (f *failureStep) Diagnose(key string, value interface{}) {}
+func (f *failureStep) Update(results ...result.Result)        { f.doc.update(results...) }
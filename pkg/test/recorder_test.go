@@ -0,0 +1,264 @@
+package test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultRecorderStepHandles(t *testing.T) {
+	r := &defaultRecorder{}
+	d := r.NewDocument("test.yaml")
+
+	first := d.NewStep("first")
+	second := d.NewStep("second")
+
+	second.Update(result.Infof("second info"))
+
+	first.Update(result.Errorf("first error"))
+	first.Diagnose("key", "value")
+	first.Close()
+
+	// Closing the first step doesn't close the second.
+	second.Update(result.Infof("still second"))
+	second.Close()
+
+	// Closing a step twice is harmless.
+	second.Close()
+
+	d.Close()
+
+	docs := r.documents()
+	require.Len(t, docs, 1)
+	require.Len(t, docs[0].Steps, 2)
+
+	steps := docs[0].Steps
+	require.Len(t, steps[0].Results, 1)
+	assert.Equal(t, "first error", steps[0].Results[0].Message)
+	assert.Equal(t, "value", steps[0].Diagnostics["key"])
+	assert.False(t, steps[0].End.IsZero())
+
+	require.Len(t, steps[1].Results, 2)
+	assert.Equal(t, "second info", steps[1].Results[0].Message)
+	assert.Equal(t, "still second", steps[1].Results[1].Message)
+
+	assert.True(t, r.Failed())
+}
+
+func TestDefaultRecorderConcurrentDocuments(t *testing.T) {
+	r := &defaultRecorder{}
+
+	first := r.NewDocument("first.yaml")
+	second := r.NewDocument("second.yaml")
+
+	s1 := first.NewStep("first step")
+	s2 := second.NewStep("second step")
+
+	s2.Update(result.Fatalf("second fatal"))
+	s1.Update(result.Infof("first info"))
+
+	first.SetProperties(map[string]interface{}{"name": "first"})
+
+	s1.Close()
+	s2.Close()
+
+	// A fatal error only stops the document it was recorded in.
+	assert.True(t, first.ShouldContinue())
+	assert.False(t, second.ShouldContinue())
+
+	first.Close()
+	second.Close()
+
+	docs := r.documents()
+	require.Len(t, docs, 2)
+
+	assert.Equal(t, "first.yaml", docs[0].Description)
+	assert.Equal(t, "first", docs[0].Properties["name"])
+	require.Len(t, docs[0].Steps, 1)
+	assert.Equal(t, "first info", docs[0].Steps[0].Results[0].Message)
+
+	assert.Equal(t, "second.yaml", docs[1].Description)
+	assert.Empty(t, docs[1].Properties)
+	require.Len(t, docs[1].Steps, 1)
+	assert.Equal(t, "second fatal", docs[1].Steps[0].Results[0].Message)
+}
+
+func TestDefaultRecorderDocumentsCopy(t *testing.T) {
+	r := &defaultRecorder{}
+
+	d := r.NewDocument("test.yaml")
+	s := d.NewStep("step")
+	s.Update(result.Infof("before"))
+
+	docs := r.documents()
+
+	s.Update(result.Infof("after"))
+	s.Diagnose("key", "value")
+	d.NewStep("another step").Close()
+	s.Close()
+	d.Close()
+
+	// Changes to the recorder don't show up in the copy.
+	require.Len(t, docs, 1)
+	require.Len(t, docs[0].Steps, 1)
+	require.Len(t, docs[0].Steps[0].Results, 1)
+	assert.Nil(t, docs[0].Steps[0].Diagnostics)
+	assert.True(t, docs[0].Steps[0].End.IsZero())
+
+	// Changes to the copy don't show up in the recorder.
+	docs[0].Steps[0].Results[0].Message = "changed"
+	docs[0].Steps = nil
+
+	docs = r.documents()
+	require.Len(t, docs[0].Steps, 2)
+	assert.Equal(t, "before", docs[0].Steps[0].Results[0].Message)
+}
+
+func TestRecordersConcurrentSteps(t *testing.T) {
+	tree := &TreeWriter{out: &bytes.Buffer{}}
+	records := &defaultRecorder{}
+	r := StackRecorders(tree, StackRecorders(NewJSONWriter(&bytes.Buffer{}), records))
+
+	d := r.NewDocument("test.yaml")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			s := d.NewStep(fmt.Sprintf("step %d", i))
+			for j := 0; j < 10; j++ {
+				s.Update(result.Infof("step %d result %d", i, j))
+				_ = d.ShouldContinue()
+			}
+
+			s.Diagnose("step", i)
+			s.Close()
+		}(i)
+	}
+
+	wg.Wait()
+	d.Close()
+
+	docs := records.documents()
+	require.Len(t, docs[0].Steps, 10)
+
+	for _, s := range docs[0].Steps {
+		require.Len(t, s.Results, 10)
+		for _, res := range s.Results {
+			assert.True(t, strings.HasPrefix(res.Message, s.Description+" "),
+				"result %q recorded in %q", res.Message, s.Description)
+		}
+	}
+}
+
+func TestTreeWriterInterleavedSteps(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := &TreeWriter{out: out, Verbose: true}
+
+	d := w.NewDocument("test.yaml")
+	first := d.NewStep("first")
+	second := d.NewStep("second")
+
+	first.Update(result.Infof("first message"))
+	second.Update(result.Infof("second message"))
+	first.Close()
+	second.Close()
+	d.Close()
+
+	// Each time the output switches between steps, the step
+	// header is repeated.
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		lines = append(lines, strings.SplitN(line, "\t", 2)[1])
+	}
+
+	require.Len(t, lines, 12)
+	assert.Equal(t, "├─ Step 0: first", lines[1])
+	assert.Equal(t, "├─ Step 1: second", lines[2])
+	assert.Equal(t, "├─ Step 0: first", lines[3])
+	assert.Equal(t, "│ ├─ first message", lines[4])
+	assert.Equal(t, "├─ Step 1: second", lines[5])
+	assert.Equal(t, "│ ├─ second message", lines[6])
+	assert.Equal(t, "├─ Step 0: first", lines[7])
+	assert.True(t, strings.HasPrefix(lines[8], "│ └─ Pass"))
+	assert.Equal(t, "├─ Step 1: second", lines[9])
+	assert.True(t, strings.HasPrefix(lines[10], "│ └─ Pass"))
+}
+
+func TestTreeWriterConcurrentDocuments(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := &TreeWriter{out: out, Verbose: true}
+
+	first := w.NewDocument("first.yaml")
+	second := w.NewDocument("second.yaml")
+
+	s2 := second.NewStep("second step")
+	s2.Update(result.Infof("second message"))
+
+	s1 := first.NewStep("first step")
+	s1.Update(result.Infof("first message"))
+
+	s2.Close()
+	second.Close()
+
+	// The second document is buffered until the first is closed.
+	assert.NotContains(t, out.String(), "second.yaml")
+
+	s1.Close()
+	first.Close()
+
+	text := out.String()
+	firstEnd := strings.Index(text, "first message")
+	secondStart := strings.Index(text, "second.yaml")
+
+	require.NotEqual(t, -1, firstEnd)
+	require.NotEqual(t, -1, secondStart)
+	assert.Less(t, firstEnd, secondStart)
+	assert.Less(t, secondStart, strings.Index(text, "second message"))
+}
+
+func TestTapWriterConcurrentDocuments(t *testing.T) {
+	out := &bytes.Buffer{}
+	w := &TapWriter{out: out}
+
+	first := w.NewDocument("first.yaml")
+	second := w.NewDocument("second.yaml")
+
+	s2 := second.NewStep("second step")
+	s2.Update(result.Errorf("second error"))
+	s2.Close()
+	second.Close()
+
+	s1 := first.NewStep("first step")
+	s1.Close()
+	first.Close()
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	// Each document's output is contiguous, in the order that
+	// the documents were opened.
+	assert.Equal(t, []string{
+		"TAP version 13",
+		"ok 1 - first step",
+		"1..1",
+		"",
+		"TAP version 13",
+		"not ok 1 - second step",
+		"1..1",
+	}, lines)
+}
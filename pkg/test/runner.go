@@ -1,9 +1,10 @@
 package test
 
 import (
+	"bytes"
 	"fmt"
-	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -11,6 +12,8 @@ import (
 	"github.com/jpeach/modden/pkg/doc"
 	"github.com/jpeach/modden/pkg/driver"
 	"github.com/jpeach/modden/pkg/filter"
+	"github.com/jpeach/modden/pkg/fixture"
+	"github.com/jpeach/modden/pkg/kustomize"
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/result"
 	"github.com/jpeach/modden/pkg/utils"
@@ -18,6 +21,7 @@ import (
 	"github.com/open-policy-agent/opa/ast"
 	"github.com/open-policy-agent/opa/rego"
 	"github.com/open-policy-agent/opa/storage"
+	"github.com/open-policy-agent/opa/util"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -35,17 +39,25 @@ func KubeClientOpt(kube *driver.KubeClient) RunOpt {
 	})
 }
 
-// RecorderOpt sets the test recorder.
-func RecorderOpt(r Recorder) RunOpt {
+// DocumentOpt sets the handle that records the test document.
+func DocumentOpt(d DocumentHandle) RunOpt {
 	return RunOpt(func(tc *testContext) {
-		tc.recorder = r
+		tc.doc = d
 	})
 }
 
-// TraceRegoOpt enables Rego tracing.
+// FixtureSetOpt sets the fixtures that are available to the test.
+func FixtureSetOpt(fixtures fixture.FixtureSet) RunOpt {
+	return RunOpt(func(tc *testContext) {
+		tc.fixtures = fixtures
+	})
+}
+
+// TraceRegoOpt enables Rego tracing for all checks. Checks can
+// also opt in to tracing individually.
 func TraceRegoOpt() RunOpt {
 	return RunOpt(func(tc *testContext) {
-		tc.regoDriver.Trace(driver.NewRegoTracer(os.Stdout))
+		tc.traceChecks = true
 	})
 }
 
@@ -59,6 +71,8 @@ func RegoParamOpt(key string, val string) RunOpt {
 		p := path.Join(parts...)
 		must.Must(tc.regoDriver.StorePath(p))
 		must.Must(tc.regoDriver.StoreItem(p, val))
+
+		tc.params = append(tc.params, key)
 	})
 }
 
@@ -85,6 +99,14 @@ func WatchResourceOpt(gvr schema.GroupVersionResource) RunOpt {
 	})
 }
 
+// ArtifactsDirOpt sets the directory that debugging artifacts
+// are written to when a test document fails.
+func ArtifactsDirOpt(dir string) RunOpt {
+	return RunOpt(func(tc *testContext) {
+		tc.artifactsDir = dir
+	})
+}
+
 // DryRunOpt enables Kuberentes dry-run mode (TODO).
 func DryRunOpt() RunOpt {
 	return RunOpt(func(tc *testContext) {
@@ -99,16 +121,43 @@ func CheckTimeoutOpt(timeout time.Duration) RunOpt {
 	})
 }
 
-func step(tc Recorder, stepDesc string, f func()) {
-	stepCloser := tc.NewStep(stepDesc)
-	defer stepCloser.Close()
+// DocumentFixtures returns the fixtures for the given document. If
+// the document metadata specifies any fixture paths, these are
+// loaded into a new FixtureSet that is layered over the base set.
+// Otherwise, the base set is returned.
+func DocumentFixtures(testDoc *doc.Document, base fixture.FixtureSet) (fixture.FixtureSet, error) {
+	md := testDoc.Metadata()
+	if md == nil || len(md.Fixtures) == 0 {
+		return base, nil
+	}
+
+	fixtures := fixture.NewLayeredSet(base)
+
+	for _, p := range md.Fixtures {
+		if !filepath.IsAbs(p) && testDoc.Name != "" {
+			p = filepath.Join(filepath.Dir(testDoc.Name), p)
+		}
+
+		if err := fixture.AddFromPath(fixtures, p); err != nil {
+			return nil, err
+		}
+	}
 
-	if !tc.ShouldContinue() {
-		tc.Update(result.Infof("skipping"))
+	return fixtures, nil
+}
+
+// step runs f as a test step. The results of the step should be
+// recorded with the StepHandle that is passed to f.
+func step(d DocumentHandle, stepDesc string, f func(StepHandle)) {
+	s := d.NewStep(stepDesc)
+	defer s.Close()
+
+	if !d.ShouldContinue() {
+		s.Update(result.Infof("skipping"))
 		return
 	}
 
-	f()
+	f(s)
 }
 
 type testContext struct {
@@ -116,13 +165,27 @@ type testContext struct {
 	objectDriver driver.ObjectDriver
 	regoDriver   driver.RegoDriver
 	envDriver    driver.Environment
-	recorder     Recorder
+	execDriver   driver.ExecDriver
+	doc          DocumentHandle
+	fixtures     fixture.FixtureSet
 
 	dryRun           bool
 	preserve         bool
+	traceChecks      bool
+	artifactsDir     string
 	checkTimeout     time.Duration
 	watchedResources []schema.GroupVersionResource
 	policyModules    []*ast.Module
+	params           []string
+	execLog          []interface{}
+	background       []*driver.ExecProcess
+	coverage         *Coverage
+
+	events         *eventLog
+	watchingEvents bool
+
+	// stepCount is the number of steps that have been run.
+	stepCount int
 }
 
 // Run executes a test document.
@@ -133,8 +196,8 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 	var err error
 
 	tc := testContext{
-		envDriver:    driver.NewEnvironment(),
 		regoDriver:   driver.NewRegoDriver(),
+		execDriver:   driver.NewExecDriver(),
 		checkTimeout: time.Second * 10,
 	}
 
@@ -142,12 +205,50 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 		o(&tc)
 	}
 
+	if tc.doc == nil {
+		return fmt.Errorf("missing test document recorder")
+	}
+
+	// Track whether this document fails, so that we can
+	// collect artifacts to help debug it.
+	failures := &failureDocument{}
+	tc.doc = stackDocuments(tc.doc, failures)
+
 	if tc.objectDriver == nil {
 		return fmt.Errorf("missing Kubernetes object driver")
 	}
 
+	fixtures := tc.fixtures
+
+	// Layer any fixtures that are specific to this document over
+	// the fixtures that we were given.
+	if md := testDoc.Metadata(); md != nil && len(md.Fixtures) > 0 {
+		step(tc.doc, "loading document fixtures", func(s StepHandle) {
+			fixtures, err = DocumentFixtures(testDoc, tc.fixtures)
+			if err != nil {
+				s.Update(result.Fatalf("%s", err))
+			}
+		})
+	}
+
+	tc.envDriver = driver.NewEnvironment(fixtures)
+
 	defer tc.objectDriver.Done()
 
+	tc.events = newEventLog(tc.envDriver.UniqueID())
+
+	for _, gvr := range tc.watchedResources {
+		if gvr == eventResource {
+			tc.watchingEvents = true
+		}
+	}
+
+	// Publish related events at `/events/$uid`. Create the
+	// path now so that checks can always refer to it.
+	if err := storeItem(tc.regoDriver, "/events", map[string]interface{}{}); err != nil {
+		return err
+	}
+
 	// Start receiving Kubernetes objects and adding them to the
 	// store. We currently don't need any locking around this since
 	// the Rego store is transactional and this path doesn't touch
@@ -155,14 +256,18 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 	cancelWatch := tc.objectDriver.Watch(cache.ResourceEventHandlerFuncs{
 		AddFunc: func(o interface{}) {
 			if u, ok := o.(*unstructured.Unstructured); ok {
-				must.Must(storeResource(tc.kubeDriver, tc.regoDriver, u))
+				must.Must(tc.updateResource(u))
 			}
 		}, UpdateFunc: func(oldObj interface{}, newObj interface{}) {
 			if u, ok := newObj.(*unstructured.Unstructured); ok {
-				must.Must(storeResource(tc.kubeDriver, tc.regoDriver, u))
+				must.Must(tc.updateResource(u))
 			}
 		}, DeleteFunc: func(o interface{}) {
 			if u, ok := o.(*unstructured.Unstructured); ok {
+				if isEvent(u) && !tc.watchingEvents {
+					return
+				}
+
 				must.Must(removeResource(tc.kubeDriver, tc.regoDriver, u))
 			}
 		},
@@ -180,15 +285,55 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 
 	tc.regoDriver.StoreItem("/test/params/run-id", tc.envDriver.UniqueID())
 
-	step(tc.recorder, "compiling test document", func() {
-		compiler, err = compileDocument(testDoc, tc.policyModules)
+	// Publish the document metadata so that checks can inspect it.
+	docProps := map[string]interface{}{"file": testDoc.Name}
+
+	md := testDoc.Metadata()
+	if md != nil {
+		tc.doc.SetProperties(md.Properties())
+
+		for k, v := range md.Properties() {
+			docProps[k] = v
+		}
+
+		if md.CheckTimeout > 0 {
+			tc.checkTimeout = md.CheckTimeout
+		}
+	}
+
+	if err := storeItem(tc.regoDriver, "/test/document", docProps); err != nil {
+		return err
+	}
+
+	if md != nil && len(md.Params) > 0 {
+		tc.step("checking required parameters", func(s *testStep) {
+			for _, p := range md.Params {
+				if !utils.ContainsString(tc.params, p) {
+					s.Update(result.Fatalf(
+						"missing required parameter %q", p))
+				}
+			}
+		})
+	}
+
+	tc.step("compiling test document", func(s *testStep) {
+		compiler, err = CompileDocument(testDoc, tc.policyModules)
 		if err != nil {
-			tc.recorder.Update(result.Fatalf("%s", err.Error()))
+			s.Update(result.Fatalf("%s", err.Error()))
+			return
+		}
+
+		if tc.coverage != nil {
+			tc.coverage.addDocument(testDoc, tc.policyModules)
 		}
 	})
 
+	// Make sure that background commands don't outlive the
+	// test, even if we don't get to stop them normally.
+	defer tc.stopBackground()
+
 	for _, p := range testDoc.Parts {
-		if !tc.recorder.ShouldContinue() {
+		if !tc.doc.ShouldContinue() {
 			break
 		}
 
@@ -203,172 +348,100 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 
 		switch p.Type {
 		case doc.FragmentTypeObject:
-			var obj *driver.Object
-			var opResult *driver.OperationResult
+			var objs []*driver.Object
 
-			step(tc.recorder,
+			tc.step(
 				fmt.Sprintf("hydrating Kubernetes object lines %s", p.Location),
-				func() {
-					obj, err = tc.envDriver.HydrateObject(p.Bytes)
+				func(s *testStep) {
+					objs, err = tc.envDriver.HydrateObjectsAt(p.Location, p.Bytes)
 					if err != nil {
-						tc.recorder.Update(
+						s.Update(
 							result.Fatalf("failed to hydrate object: %s", err))
 						return
 					}
 
-					if obj.Object.GetName() == "" {
-						tc.recorder.Update(
-							result.Infof("hydrated anonymous %s:%s object",
-								obj.Object.GetAPIVersion(),
-								obj.Object.GetKind()))
-					} else {
-						tc.recorder.Update(
-							result.Infof("hydrated %s:%s object '%s/%s'",
-								obj.Object.GetAPIVersion(),
-								obj.Object.GetKind(),
-								utils.NamespaceOrDefault(obj.Object),
-								obj.Object.GetName()))
+					for _, obj := range objs {
+						// Expectations can't be matched by label
+						// since they don't have the run ID.
+						if obj.Operation == driver.ObjectOperationExpect &&
+							obj.Object.GetName() == "" {
+							s.Update(
+								result.Fatalf("expected %s:%s object has no name",
+									obj.Object.GetAPIVersion(),
+									obj.Object.GetKind()))
+							objs = nil
+							return
+						}
+
+						if obj.Object.GetName() == "" {
+							s.Update(
+								result.Infof("hydrated anonymous %s:%s object",
+									obj.Object.GetAPIVersion(),
+									obj.Object.GetKind()))
+						} else {
+							s.Update(
+								result.Infof("hydrated %s:%s object '%s/%s'",
+									obj.Object.GetAPIVersion(),
+									obj.Object.GetKind(),
+									utils.NamespaceOrDefault(obj.Object),
+									obj.Object.GetName()))
+						}
 					}
 				})
 
-			// If we don't have an object name, try to
-			// select it using the labels. Note that we
-			// may have to wait here, because the objects
-			// we want to select may not have been created
-			// yet.
-			step(tc.recorder, "matching anonymous Kubernetes object", func() {
-				if obj.Object.GetName() != "" {
-					return
-				}
-
-				s := utils.NewSelectorFromObject(obj.Object)
-
-				tc.recorder.Update(result.Infof(
-					"matching anonymous %s:%s object",
-					obj.Object.GetAPIVersion(), obj.Object.GetKind()))
-
-				tc.recorder.Update(result.Infof("selector %q", s.String()))
-
-				// TODO(jpeach): select on namespace if present?
-
-				candidates, err := tc.kubeDriver.SelectObjects(
-					obj.Object.GroupVersionKind(),
-					utils.NewSelectorFromObject(obj.Object))
-				if err != nil {
-					tc.recorder.Update(result.Fatalf(
-						"listing %s:%s objects: %s",
-						obj.Object.GetAPIVersion(), obj.Object.GetKind(), err))
-					return
-				}
-
-				var match *unstructured.Unstructured
-				for _, u := range candidates {
-					if filter.ObjectRunID(u) == tc.envDriver.UniqueID() {
-						match = u
-						break
-					}
-				}
-
-				if match == nil {
-					tc.recorder.Update(result.Fatalf(
-						"failed to match object with run ID %s",
-						tc.envDriver.UniqueID()))
-					return
-				}
-
-				obj.Object = match
-				tc.recorder.Update(result.Infof(
-					"matched %s:%s object '%s/%s'",
-					obj.Object.GetAPIVersion(),
-					obj.Object.GetKind(),
-					utils.NamespaceOrDefault(obj.Object),
-					obj.Object.GetName()))
-
-			})
-
-			step(tc.recorder, "updating Kubernetes object", func() {
-				tc.recorder.Update(result.Infof(
-					"performing %s operation on %s '%s/%s'",
-					obj.Operation,
-					obj.Object.GetKind(),
-					utils.NamespaceOrDefault(obj.Object),
-					obj.Object.GetName()))
-
-				switch obj.Operation {
-				case driver.ObjectOperationUpdate:
-					opResult, err = applyObject(tc.kubeDriver, tc.objectDriver, obj.Object)
-				case driver.ObjectOperationDelete:
-					opResult, err = tc.objectDriver.Delete(obj.Object)
+			// A fixture group hydrates to multiple objects,
+			// which are applied and checked in order.
+			for _, obj := range objs {
+				if !tc.doc.ShouldContinue() {
+					break
 				}
 
-				if err != nil {
-					// TODO(jpeach): this should be treated as a fatal test error.
-					tc.recorder.Update(result.Fatalf(
-						"unable to %s object: %s", obj.Operation, err))
-					return
-				}
-
-				if opResult.Latest != nil {
-					// First, push the result into the store.
-					if err := storeItem(tc.regoDriver, "/resources/applied/last",
-						opResult.Latest.UnstructuredContent()); err != nil {
-						tc.recorder.Update(result.Fatalf(
-							"failed to store result: %s", err))
-						return
-					}
+				tc.runObject(compiler, obj)
+			}
 
-					// TODO(jpeach): create an array at `/resources/applied/log` and append this.
-				}
-			})
-
-			step(tc.recorder, "running object update check", func() {
-				tc.recorder.Update(result.Infof(
-					"checking %s of %s '%s/%s'",
-					obj.Operation,
-					obj.Object.GetKind(),
-					utils.NamespaceOrDefault(obj.Object),
-					obj.Object.GetName()))
-
-				check := obj.Check
-				opts := []driver.RegoOpt{
-					rego.Compiler(compiler),
-					rego.Input(opResult),
-				}
+		case doc.FragmentTypeKustomize:
+			var objs []*driver.Object
 
-				// If we have a check from the object,
-				// it has not been added to the compiler,
-				// so we need to pass it in as a parsed
-				// module. Otherwise, we can use the
-				// default check which the compiler had
-				// already compiled.
-				if check != nil {
-					opts = append(opts, rego.ParsedModule(check))
-				} else {
-					check = DefaultObjectCheckForOperation(obj.Operation)
-				}
+			tc.step(
+				fmt.Sprintf("building kustomization lines %s", p.Location),
+				func(s *testStep) {
+					objs = tc.buildKustomization(s, &p)
+				})
 
-				checkResults, err := runCheck(
-					tc.regoDriver, check, tc.checkTimeout, opts...)
-				if err != nil {
-					tc.recorder.Update(result.Fatalf("%s", err))
+			for _, obj := range objs {
+				if !tc.doc.ShouldContinue() {
+					break
 				}
 
-				tc.recorder.Update(checkResults...)
-			})
+				tc.runObject(compiler, obj)
+			}
 
 		case doc.FragmentTypeModule:
-			step(tc.recorder,
+			tc.step(
 				fmt.Sprintf("running Rego check lines %s", p.Location),
-				func() {
-					checkResults, err := runCheck(
-						tc.regoDriver, p.Rego(), tc.checkTimeout, rego.Compiler(compiler))
-					if err != nil {
-						tc.recorder.Update(result.Fatalf("%s", err))
-					}
+				func(s *testStep) {
+					tc.checkStep(s, p.Rego(), nil, false, rego.Compiler(compiler))
+				})
+
+		case doc.FragmentTypeExec:
+			if p.Exec().Background {
+				tc.step(
+					fmt.Sprintf("starting background command lines %s", p.Location),
+					func(s *testStep) {
+						s.Update(startExec(&tc, &p)...)
+					})
+				break
+			}
 
-					tc.recorder.Update(checkResults...)
+			tc.step(
+				fmt.Sprintf("running local command lines %s", p.Location),
+				func(s *testStep) {
+					s.Update(runExec(&tc, &p)...)
 				})
 
+		case doc.FragmentTypeMetadata:
+			// Metadata was handled before running the document.
+
 		case doc.FragmentTypeUnknown:
 			// Ignore unknown fragments.
 
@@ -380,6 +453,26 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 		}
 	}
 
+	// Stop background commands at the end of the document. We
+	// do this before collecting artifacts so that a failed
+	// background command causes artifacts to be collected.
+	tc.stopBackground()
+
+	// Collect artifacts before we delete the test objects. We
+	// do this even if the test was stopped by a fatal error.
+	if failures.Failed() && tc.artifactsDir != "" && tc.kubeDriver != nil {
+		s := tc.doc.NewStep("collecting failure artifacts")
+		paths := tc.collectArtifacts(s)
+		s.Close()
+
+		// This step usually passes, so recorders may hide
+		// its results. Record the artifact paths as a document
+		// property so that they are always shown.
+		tc.doc.SetProperties(map[string]interface{}{
+			PropertyArtifacts: stringsToInterfaces(paths),
+		})
+	}
+
 	if !tc.preserve {
 		must.Must(tc.objectDriver.DeleteAll())
 	}
@@ -388,6 +481,351 @@ func Run(testDoc *doc.Document, opts ...RunOpt) error {
 	return nil
 }
 
+// testStep is a StepHandle for a numbered step of a test document.
+type testStep struct {
+	StepHandle
+
+	// num and desc identify the step.
+	num  int
+	desc string
+}
+
+// step runs a test step, recording any Kubernetes events that
+// are related to the test as step diagnostics.
+func (tc *testContext) step(stepDesc string, f func(*testStep)) {
+	tc.stepCount++
+	num := tc.stepCount
+
+	// Only attach the events that arrived during this step.
+	mark := tc.events.Mark()
+
+	step(tc.doc, stepDesc, func(s StepHandle) {
+		f(&testStep{StepHandle: s, num: num, desc: stepDesc})
+
+		if events := tc.events.LinesSince(mark); len(events) > 0 {
+			s.Diagnose(DiagnosticEvents, events)
+		}
+	})
+}
+
+// watchEvents starts watching the events in the namespace of the
+// given object, so that we can explain failures. Events are only
+// watched in the namespaces of the objects that the test applies,
+// unless all events were explicitly watched. Any error is recorded
+// in the given step.
+func (tc *testContext) watchEvents(s StepHandle, u *unstructured.Unstructured) {
+	if tc.watchingEvents {
+		return
+	}
+
+	ns := utils.NamespaceOrDefault(u)
+	if err := tc.objectDriver.InformOnNamespace(eventResource, ns); err != nil {
+		s.Update(result.Errorf(
+			"failed to watch events in namespace %q: %s", ns, err))
+	}
+}
+
+// updateResource stores an updated Kubernetes object. Events are
+// published at `/events` if they relate to the test, and are only
+// stored in the resources hierarchy if they are explicitly watched.
+func (tc *testContext) updateResource(u *unstructured.Unstructured) error {
+	if !isEvent(u) {
+		// Events that arrived before the object was related
+		// to the test are published now.
+		for _, e := range tc.events.AddObject(u, tc.objectDriver.IsAdopted) {
+			if err := storeEvent(tc.regoDriver, e); err != nil {
+				return err
+			}
+		}
+
+		return storeResource(tc.kubeDriver, tc.regoDriver, u)
+	}
+
+	if tc.events.AddEvent(u, tc.objectDriver.IsAdopted) {
+		if err := storeEvent(tc.regoDriver, u); err != nil {
+			return err
+		}
+	}
+
+	if !tc.watchingEvents {
+		return nil
+	}
+
+	return storeResource(tc.kubeDriver, tc.regoDriver, u)
+}
+
+// buildKustomization builds the kustomization referenced by the
+// given fragment and hydrates all the resulting objects.
+func (tc *testContext) buildKustomization(s StepHandle, p *doc.Fragment) []*driver.Object {
+	dir := p.Kustomize().Dir
+
+	// Relative kustomization paths are relative to the
+	// document the fragment came from.
+	if !filepath.IsAbs(dir) && p.Location.File != "" {
+		dir = filepath.Join(filepath.Dir(p.Location.File), dir)
+	}
+
+	s.Update(result.Infof("building kustomization %q", dir))
+
+	nodes, err := kustomize.Build(dir)
+	if err != nil {
+		s.Update(result.Fatalf(
+			"failed to build kustomization %q: %s", dir, err))
+		return nil
+	}
+
+	objs := make([]*driver.Object, 0, len(nodes))
+
+	for _, n := range nodes {
+		obj, err := tc.envDriver.HydrateObject([]byte(n.MustString()))
+		if err != nil {
+			s.Update(
+				result.Fatalf("failed to hydrate object: %s", err))
+			return nil
+		}
+
+		s.Update(
+			result.Infof("hydrated %s:%s object '%s/%s'",
+				obj.Object.GetAPIVersion(),
+				obj.Object.GetKind(),
+				utils.NamespaceOrDefault(obj.Object),
+				obj.Object.GetName()))
+
+		objs = append(objs, obj)
+	}
+
+	return objs
+}
+
+// runObject runs the steps to apply the hydrated Kubernetes object,
+// and check the result.
+func (tc *testContext) runObject(compiler *ast.Compiler, obj *driver.Object) {
+	var opResult *driver.OperationResult
+	var err error
+
+	// If we don't have an object name, try to
+	// select it using the labels. Note that we
+	// may have to wait here, because the objects
+	// we want to select may not have been created
+	// yet.
+	tc.step("matching anonymous Kubernetes object", func(s *testStep) {
+		if obj.Object.GetName() != "" {
+			return
+		}
+
+		selector := utils.NewSelectorFromObject(obj.Object)
+
+		s.Update(result.Infof(
+			"matching anonymous %s:%s object",
+			obj.Object.GetAPIVersion(), obj.Object.GetKind()))
+
+		s.Update(result.Infof("selector %q", selector.String()))
+
+		// TODO(jpeach): select on namespace if present?
+
+		candidates, err := tc.kubeDriver.SelectObjects(
+			obj.Object.GroupVersionKind(),
+			utils.NewSelectorFromObject(obj.Object))
+		if err != nil {
+			s.Update(result.Fatalf(
+				"listing %s:%s objects: %s",
+				obj.Object.GetAPIVersion(), obj.Object.GetKind(), err))
+			return
+		}
+
+		var match *unstructured.Unstructured
+		for _, u := range candidates {
+			if filter.ObjectRunID(u) == tc.envDriver.UniqueID() {
+				match = u
+				break
+			}
+		}
+
+		if match == nil {
+			s.Update(result.Fatalf(
+				"failed to match object with run ID %s",
+				tc.envDriver.UniqueID()))
+			return
+		}
+
+		obj.Object = match
+		s.Update(result.Infof(
+			"matched %s:%s object '%s/%s'",
+			obj.Object.GetAPIVersion(),
+			obj.Object.GetKind(),
+			utils.NamespaceOrDefault(obj.Object),
+			obj.Object.GetName()))
+
+	})
+
+	if obj.Operation == driver.ObjectOperationExpect {
+		tc.step("matching Kubernetes object expectation", func(s *testStep) {
+			s.Update(result.Infof(
+				"expecting %s '%s/%s'",
+				obj.Object.GetKind(),
+				utils.NamespaceOrDefault(obj.Object),
+				obj.Object.GetName()))
+
+			s.Update(expectObject(
+				tc.kubeDriver, tc.objectDriver, tc.regoDriver,
+				obj.Object, tc.checkTimeout)...)
+		})
+
+		return
+	}
+
+	tc.step("updating Kubernetes object", func(s *testStep) {
+		s.Update(result.Infof(
+			"performing %s operation on %s '%s/%s'",
+			obj.Operation,
+			obj.Object.GetKind(),
+			utils.NamespaceOrDefault(obj.Object),
+			obj.Object.GetName()))
+
+		switch obj.Operation {
+		case driver.ObjectOperationUpdate:
+			tc.watchEvents(s, obj.Object)
+			opResult, err = applyObject(tc.kubeDriver, tc.objectDriver, obj.Object)
+		case driver.ObjectOperationDelete:
+			opResult, err = tc.objectDriver.Delete(obj.Object)
+		}
+
+		if err != nil {
+			// TODO(jpeach): this should be treated as a fatal test error.
+			s.Update(result.Fatalf(
+				"unable to %s object: %s", obj.Operation, err))
+			return
+		}
+
+		if opResult.Latest != nil {
+			// First, push the result into the store.
+			if err := storeItem(tc.regoDriver, "/resources/applied/last",
+				opResult.Latest.UnstructuredContent()); err != nil {
+				s.Update(result.Fatalf(
+					"failed to store result: %s", err))
+				return
+			}
+
+			// TODO(jpeach): create an array at `/resources/applied/log` and append this.
+		}
+	})
+
+	tc.step("running object update check", func(s *testStep) {
+		s.Update(result.Infof(
+			"checking %s of %s '%s/%s'",
+			obj.Operation,
+			obj.Object.GetKind(),
+			utils.NamespaceOrDefault(obj.Object),
+			obj.Object.GetName()))
+
+		check := obj.Check
+		opts := []driver.RegoOpt{
+			rego.Compiler(compiler),
+		}
+
+		// If we have a check from the object,
+		// it has not been added to the compiler,
+		// so we need to pass it in as a parsed
+		// module. Otherwise, we can use the
+		// default check which the compiler had
+		// already compiled.
+		//
+		// Object checks have the file name of
+		// the document, and Rego keys parsed
+		// modules by file name when it compiles
+		// them into the shared compiler, so this
+		// replaces any earlier object check from
+		// the same document. That is OK because
+		// each check has a unique package, and is
+		// only evaluated once.
+		if check != nil {
+			opts = append(opts, rego.ParsedModule(check))
+
+			if tc.coverage != nil {
+				tc.coverage.addCheck(check, obj.CheckLocation)
+			}
+		} else {
+			check = DefaultObjectCheckForOperation(obj.Operation)
+		}
+
+		tc.checkStep(s, check, opResult, obj.Trace, opts...)
+	})
+}
+
+// DiagnosticTrace is the Step.Diagnostics key for the Rego trace of
+// the last evaluation of a failing check.
+const DiagnosticTrace = "trace"
+
+// maxTraceLines is the maximum number of trace lines that are
+// attached to a step when the trace isn't written to an artifact.
+const maxTraceLines = 50
+
+// truncateTrace returns the last max lines of the trace. If any lines
+// were dropped, the first line notes how many, and how to get the
+// full trace.
+func truncateTrace(lines []string, max int) []string {
+	if len(lines) <= max {
+		return lines
+	}
+
+	omitted := len(lines) - max
+	truncated := make([]string, 0, max+1)
+	truncated = append(truncated, fmt.Sprintf(
+		"... %d earlier lines omitted, use --artifacts to write the full trace to trace.txt", omitted))
+
+	return append(truncated, lines[omitted:]...)
+}
+
+// checkStep runs a check and records its results in the test step.
+// The check is traced if tracing is enabled for all checks, if trace
+// is true, or if the check module has a trace comment. The trace of
+// the last evaluation is only kept if the check fails.
+func (tc *testContext) checkStep(
+	s *testStep,
+	check *ast.Module,
+	input interface{},
+	trace bool,
+	opts ...driver.RegoOpt) {
+	var traceBuf *bytes.Buffer
+
+	if tc.traceChecks || trace || doc.IsTraced(check) {
+		traceBuf = &bytes.Buffer{}
+	}
+
+	if input != nil {
+		opts = append(opts, rego.Input(input))
+	}
+
+	checkResults, stats, err := runCheck(
+		tc.regoDriver, check, tc.checkTimeout, traceBuf, opts...)
+	if err != nil {
+		s.Update(result.Fatalf("%s", err))
+	}
+
+	s.Diagnose(DiagnosticCheck, stats)
+	s.Update(checkResults...)
+
+	if err == nil && !result.Contains(checkResults, result.SeverityError) &&
+		!result.Contains(checkResults, result.SeverityFatal) {
+		return
+	}
+
+	var traceLines []string
+	if traceBuf != nil && traceBuf.Len() > 0 {
+		traceLines = strings.Split(strings.TrimSuffix(traceBuf.String(), "\n"), "\n")
+	}
+
+	// If we are collecting artifacts, the trace is written to
+	// a file along with the rest of the check state, since it
+	// can be very long. Otherwise, attach the end of it to the
+	// step, so that it doesn't swamp the test output.
+	if tc.artifactsDir != "" {
+		tc.dumpCheckState(s, check, input, traceLines)
+	} else if len(traceLines) > 0 {
+		s.Diagnose(DiagnosticTrace, truncateTrace(traceLines, maxTraceLines))
+	}
+}
+
 func applyObject(k *driver.KubeClient,
 	o driver.ObjectDriver,
 	u *unstructured.Unstructured) (*driver.OperationResult, error) {
@@ -424,8 +862,138 @@ func applyObject(k *driver.KubeClient,
 	return o.Apply(u)
 }
 
-// compileDocument compiles all the Rego policies in the test document.
-func compileDocument(d *doc.Document, modules []*ast.Module) (*ast.Compiler, error) {
+// runExec runs the local command in the given fragment and publishes
+// the result into the Rego data document at `/exec/last`. Each result
+// is also appended to the array at `/exec/log`.
+func runExec(tc *testContext, p *doc.Fragment) []result.Result {
+	e := execFor(p)
+
+	results := []result.Result{
+		result.Infof("running %s", strings.Join(append([]string{e.Command}, e.Args...), " ")),
+	}
+
+	res, err := tc.execDriver.Run(&e)
+	if err != nil {
+		return append(results, result.Fatalf("failed to run %q: %s", e.Command, err))
+	}
+
+	var item interface{} = res
+	if err := util.RoundTrip(&item); err != nil {
+		return append(results, result.Fatalf("failed to convert exec result: %s", err))
+	}
+
+	tc.execLog = append(tc.execLog, item)
+
+	if err := storeItem(tc.regoDriver, "/exec/last", item); err != nil {
+		return append(results, result.Fatalf("failed to store result: %s", err))
+	}
+
+	if err := storeItem(tc.regoDriver, "/exec/log", tc.execLog); err != nil {
+		return append(results, result.Fatalf("failed to store result: %s", err))
+	}
+
+	switch {
+	case res.TimedOut:
+		results = append(results, result.Errorf(
+			"command %q timed out after %s", e.Command, res.Duration))
+	case res.ExitStatus != 0:
+		results = append(results, result.Errorf(
+			"command %q exited with status %d", e.Command, res.ExitStatus))
+	default:
+		results = append(results, result.Infof(
+			"command %q exited with status %d", e.Command, res.ExitStatus))
+	}
+
+	if !res.Succeeded() && res.Stderr != "" {
+		results = append(results, result.Infof("stderr: %s", strings.TrimSpace(res.Stderr)))
+	}
+
+	return results
+}
+
+// execFor returns the local command in the given fragment.
+func execFor(p *doc.Fragment) doc.Exec {
+	e := *p.Exec()
+
+	// Relative working directories are relative to the
+	// document the command came from.
+	if e.Dir != "" && !filepath.IsAbs(e.Dir) && p.Location.File != "" {
+		e.Dir = filepath.Join(filepath.Dir(p.Location.File), e.Dir)
+	}
+
+	return e
+}
+
+// startExec starts the local command in the given fragment in the
+// background. The command is stopped at the end of the document.
+func startExec(tc *testContext, p *doc.Fragment) []result.Result {
+	e := execFor(p)
+
+	results := []result.Result{
+		result.Infof("starting %s", strings.Join(append([]string{e.Command}, e.Args...), " ")),
+	}
+
+	proc, err := tc.execDriver.Start(&e)
+	if err != nil {
+		return append(results, result.Fatalf("failed to start %q: %s", e.Command, err))
+	}
+
+	tc.background = append(tc.background, proc)
+
+	return results
+}
+
+// stopBackground stops the background commands in the reverse order
+// that they were started. Unlike other steps, this runs even if the
+// test was stopped by a fatal error, since the commands would
+// otherwise keep running after modden exits.
+func (tc *testContext) stopBackground() {
+	for i := len(tc.background) - 1; i >= 0; i-- {
+		p := tc.background[i]
+
+		s := tc.doc.NewStep(fmt.Sprintf("stopping background command %q", p.Command()))
+		s.Update(stopExec(p)...)
+		s.Close()
+	}
+
+	tc.background = nil
+}
+
+// stopExec stops a background command. It is an error for the
+// command to have already exited with a non-zero status.
+func stopExec(p *driver.ExecProcess) []result.Result {
+	res, err := p.Stop()
+	if err != nil {
+		return []result.Result{
+			result.Errorf("failed to stop %q: %s", p.Command(), err),
+		}
+	}
+
+	var results []result.Result
+
+	switch {
+	case res.Stopped:
+		results = append(results, result.Infof(
+			"stopped command %q after %s", res.Command, res.Duration))
+	case res.ExitStatus != 0:
+		results = append(results, result.Errorf(
+			"command %q exited with status %d", res.Command, res.ExitStatus))
+	default:
+		results = append(results, result.Infof(
+			"command %q exited with status %d", res.Command, res.ExitStatus))
+	}
+
+	if !res.Succeeded() && res.Stderr != "" {
+		results = append(results, result.Infof("stderr: %s", strings.TrimSpace(res.Stderr)))
+	}
+
+	return results
+}
+
+// CompileDocument compiles all the Rego policies in the test
+// document, together with the built-in policies and the given
+// additional policy modules.
+func CompileDocument(d *doc.Document, modules []*ast.Module) (*ast.Compiler, error) {
 	compiler := ast.NewCompiler()
 	modmap := map[string]*ast.Module{}
 
@@ -456,7 +1024,9 @@ func compileDocument(d *doc.Document, modules []*ast.Module) (*ast.Compiler, err
 		modmap[name] = m
 	}
 
-	// Finally, add all the check modules in the document.
+	// Finally, add all the check modules in the document. The
+	// fragments of a document share its file name, so we key them
+	// by their unique package path.
 	for _, p := range d.Parts {
 		switch p.Type {
 		case doc.FragmentTypeModule:
@@ -476,37 +1046,69 @@ func compileDocument(d *doc.Document, modules []*ast.Module) (*ast.Compiler, err
 	return compiler, nil
 }
 
+// runCheck evaluates the check module until it passes, or until
+// the timeout expires. It returns the final check results, and
+// statistics about how the check was polled. If trace is not nil,
+// each evaluation is traced and trace holds the trace of the last
+// evaluation when runCheck returns.
 func runCheck(
 	c driver.RegoDriver,
 	m *ast.Module,
 	timeout time.Duration,
-	opts ...driver.RegoOpt) ([]result.Result, error) {
+	trace *bytes.Buffer,
+	opts ...driver.RegoOpt) ([]result.Result, CheckStats, error) {
 	var err error
 	var results []result.Result
+	var stats CheckStats
 
 	startTime := time.Now()
 
 	for time.Since(startTime) < timeout {
-		results, err = c.Eval(m, opts...)
+		evalOpts := opts
+
+		// Trace each evaluation into a fresh buffer, so that
+		// only the last one is kept.
+		var tracer driver.RegoTracer
+		if trace != nil {
+			trace.Reset()
+			tracer = driver.NewRegoTracer(trace)
+			evalOpts = append([]driver.RegoOpt{rego.Tracer(tracer)}, opts...)
+		}
+
+		results, err = c.Eval(m, evalOpts...)
+		stats.Attempts++
+
+		if tracer != nil {
+			tracer.Write()
+		}
+
+		stats.Elapsed = time.Since(startTime)
+
 		if err != nil {
-			return nil, err
+			return nil, stats, err
 		}
 
 		if len(results) == 0 {
-			return nil, nil
+			stats.Converged = true
+			return nil, stats, nil
 		}
 
 		// If we have a skip result, skip now rather than
 		// waiting for the timeout. It makes no sense to wait,
 		// since skipping should be a permenent status.
 		if result.Contains(results, result.SeveritySkip) {
-			return results, err
+			stats.Converged = true
+			return results, stats, err
 		}
 
 		time.Sleep(time.Millisecond * 500)
 	}
 
-	return results, err
+	stats.Elapsed = time.Since(startTime)
+	stats.Converged = !result.Contains(results, result.SeverityError) &&
+		!result.Contains(results, result.SeverityFatal)
+
+	return results, stats, err
 }
 
 // Resources in the default namespace are stored as:
@@ -540,6 +1142,12 @@ func storeItem(c driver.RegoDriver, where string, what interface{}) error {
 	return err
 }
 
+// storeEvent publishes an event that is related to the test at
+// `/events/$uid` in the Rego data document.
+func storeEvent(c driver.RegoDriver, u *unstructured.Unstructured) error {
+	return storeItem(c, path.Join("/", "events", string(u.GetUID())), u.UnstructuredContent())
+}
+
 // storeResourceVersions queries the API server for all resource
 // versions, and stores a list of GroupVersionKind objects at the
 // path '/resources/$RESOURCE/.versions'. This lets test documents
@@ -1,10 +1,21 @@
 package test
 
 import (
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/doc"
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/result"
+	"github.com/jpeach/modden/pkg/utils"
 
 	"github.com/magiconair/properties/assert"
+	"github.com/open-policy-agent/opa/rego"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 func TestPathforResource(t *testing.T) {
@@ -34,3 +45,290 @@ func TestPathforResource(t *testing.T) {
 		"/resources/services/two",
 	)
 }
+
+func TestRunExec(t *testing.T) {
+	tc := testContext{
+		regoDriver: driver.NewRegoDriver(),
+		execDriver: driver.NewExecDriver(),
+	}
+
+	run := func(data string) []result.Result {
+		p := doc.Fragment{Bytes: []byte(data)}
+		if _, err := p.Decode(); err != nil {
+			t.Fatalf("failed to decode %q: %s", data, err)
+		}
+
+		return runExec(&tc, &p)
+	}
+
+	results := run(`
+$exec:
+  command: echo
+  args: [hello]
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityError), false)
+
+	last, err := tc.regoDriver.ReadItem("/exec/last/stdout")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, last, "hello\n")
+
+	results = run(`
+$exec:
+  command: "false"
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityError), true)
+
+	log, err := tc.regoDriver.ReadItem("/exec/log")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(log.([]interface{})), 2)
+
+	results = run(`
+$exec:
+  command: /no/such/command
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityFatal), true)
+}
+
+func TestBackgroundExec(t *testing.T) {
+	tc := testContext{
+		regoDriver: driver.NewRegoDriver(),
+		execDriver: driver.NewExecDriver(),
+	}
+
+	start := func(data string) []result.Result {
+		p := doc.Fragment{Bytes: []byte(data)}
+		if _, err := p.Decode(); err != nil {
+			t.Fatalf("failed to decode %q: %s", data, err)
+		}
+
+		return startExec(&tc, &p)
+	}
+
+	results := start(`
+$exec:
+  command: sleep
+  args: ["60"]
+  background: true
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityFatal), false)
+	assert.Equal(t, len(tc.background), 1)
+
+	results = stopExec(tc.background[0])
+	assert.Equal(t, result.Contains(results, result.SeverityError), false)
+
+	results = start(`
+$exec:
+  command: "false"
+  background: true
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityFatal), false)
+	assert.Equal(t, len(tc.background), 2)
+
+	for !tc.background[1].Exited() {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results = stopExec(tc.background[1])
+	assert.Equal(t, result.Contains(results, result.SeverityError), true)
+
+	results = start(`
+$exec:
+  command: /no/such/command
+  background: true
+`)
+
+	assert.Equal(t, result.Contains(results, result.SeverityFatal), true)
+}
+
+func TestStopBackgroundAfterFatal(t *testing.T) {
+	r := &defaultRecorder{}
+
+	tc := testContext{
+		regoDriver: driver.NewRegoDriver(),
+		execDriver: driver.NewExecDriver(),
+		events:     newEventLog("test"),
+	}
+
+	d := r.NewDocument("test")
+	tc.doc = d
+
+	p := doc.Fragment{Bytes: []byte(`
+$exec:
+  command: sleep
+  args: ["60"]
+  background: true
+`)}
+
+	if _, err := p.Decode(); err != nil {
+		t.Fatalf("failed to decode exec fragment: %s", err)
+	}
+
+	tc.step("starting background command", func(s *testStep) {
+		s.Update(startExec(&tc, &p)...)
+	})
+
+	tc.step("failing fatally", func(s *testStep) {
+		s.Update(result.Fatalf("fatal"))
+	})
+
+	assert.Equal(t, d.ShouldContinue(), false)
+	assert.Equal(t, len(tc.background), 1)
+
+	proc := tc.background[0]
+	tc.stopBackground()
+
+	d.Close()
+
+	// The command was stopped even though the test can't continue.
+	assert.Equal(t, proc.Exited(), true)
+	assert.Equal(t, len(tc.background), 0)
+
+	steps := r.docs[0].Steps
+	assert.Equal(t, steps[len(steps)-1].Description, `stopping background command "sleep"`)
+	assert.Equal(t, result.Contains(steps[len(steps)-1].Results, result.SeverityError), false)
+
+	// Stopping again is a no-op.
+	tc.stopBackground()
+	assert.Equal(t, len(r.docs[0].Steps), len(steps))
+}
+
+// forbiddenInformer is an ObjectDriver that fails to inform on
+// any namespace.
+type forbiddenInformer struct {
+	driver.ObjectDriver
+}
+
+func (forbiddenInformer) InformOnNamespace(schema.GroupVersionResource, string) error {
+	return errors.New("forbidden")
+}
+
+func TestWatchEventsError(t *testing.T) {
+	r := &defaultRecorder{}
+	d := r.NewDocument("test")
+
+	tc := testContext{
+		objectDriver: forbiddenInformer{},
+		doc:          d,
+		events:       newEventLog("test"),
+	}
+
+	u := &unstructured.Unstructured{}
+	u.SetNamespace("test")
+
+	tc.step("updating Kubernetes object", func(s *testStep) {
+		tc.watchEvents(s, u)
+	})
+
+	d.Close()
+
+	results := r.docs[0].Steps[0].Results
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Severity, result.SeverityError)
+	assert.Equal(t, results[0].Message, `failed to watch events in namespace "test": forbidden`)
+}
+
+func TestCheckModulesShareFile(t *testing.T) {
+	d := &doc.Document{Parts: []doc.Fragment{
+		{
+			Bytes:    []byte(`error[msg] { false; msg := "first" }`),
+			Location: doc.Location{File: "test.yaml", Start: 1, End: 1},
+		},
+		{
+			Bytes:    []byte(`error[msg] { false; msg := "second" }`),
+			Location: doc.Location{File: "test.yaml", Start: 3, End: 3},
+		},
+	}}
+
+	for i := range d.Parts {
+		if _, err := d.Parts[i].Decode(); err != nil {
+			t.Fatalf("failed to decode fragment %d: %s", i, err)
+		}
+	}
+
+	// Fragments from the same file are compiled separately.
+	compiler, err := CompileDocument(d, nil)
+	assert.Equal(t, err, nil)
+
+	for _, p := range d.Parts {
+		_, ok := compiler.Modules["doc/"+p.Rego().Package.Path.String()]
+		assert.Equal(t, ok, true)
+	}
+
+	// Object checks from the same file are passed as parsed
+	// modules, and each evaluation must run its own check.
+	r := driver.NewRegoDriver()
+
+	for i, msg := range []string{"one", "two"} {
+		m, err := utils.ParseCheckFragmentAt("test.yaml", 5+i,
+			fmt.Sprintf(`error[msg] { msg := %q }`, msg))
+		assert.Equal(t, err, nil)
+
+		results, err := r.Eval(m, rego.Compiler(compiler), rego.ParsedModule(m))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, strings.HasSuffix(results[0].Message, msg), true)
+	}
+}
+
+func TestCheckStepTrace(t *testing.T) {
+	tc := testContext{
+		regoDriver:   driver.NewRegoDriver(),
+		checkTimeout: 100 * time.Millisecond,
+	}
+
+	// check runs the given check in a step, returning the step
+	// trace diagnostic, if there is one.
+	check := func(data string, trace bool) interface{} {
+		p, err := doc.NewRegoFragment([]byte(data))
+		if err != nil {
+			t.Fatalf("failed to decode %q: %s", data, err)
+		}
+
+		r := &defaultRecorder{}
+		d := r.NewDocument("test")
+		s := &testStep{StepHandle: d.NewStep("check"), num: 1, desc: "check"}
+
+		tc.checkStep(s, p.Rego(), nil, trace, rego.ParsedModule(p.Rego()))
+
+		s.Close()
+		d.Close()
+
+		return r.docs[0].Steps[0].Diagnostics[DiagnosticTrace]
+	}
+
+	failing := `error[msg] { msg := "always fails" }`
+	passing := `error[msg] { false; msg := "never fails" }`
+
+	// Checks are only traced if they opt in.
+	assert.Equal(t, check(failing, false), nil)
+	assert.Equal(t, check("# $trace\n"+failing, false) != nil, true)
+	assert.Equal(t, check(failing, true) != nil, true)
+
+	// Traces of passing checks are discarded.
+	assert.Equal(t, check(passing, true), nil)
+
+	tc.traceChecks = true
+
+	lines, ok := check(failing, false).([]string)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, len(lines) > 0, true)
+	assert.Equal(t, len(lines) <= maxTraceLines+1, true)
+	assert.Equal(t, check(passing, false), nil)
+}
+
+func TestTruncateTrace(t *testing.T) {
+	lines := []string{"1", "2", "3", "4", "5"}
+
+	assert.Equal(t, truncateTrace(lines, 5), lines)
+	assert.Equal(t, truncateTrace(lines, 10), lines)
+	assert.Equal(t, truncateTrace(lines, 2), []string{
+		"... 3 earlier lines omitted, use --artifacts to write the full trace to trace.txt",
+		"4",
+		"5",
+	})
+}
@@ -2,7 +2,11 @@ package test
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/result"
@@ -13,27 +17,31 @@ import (
 // TapWriter writes test records in TAP format.
 // See https://testanything.org/tap-version-13-specification.html
 type TapWriter struct {
-	docCount  int
-	stepCount int
+	out io.Writer
 
-	stepErrors []result.Result
-	stepSkips  []result.Result
+	lock     sync.Mutex
+	docCount int
+	serial   serialOutput
 }
 
 var _ Recorder = &TapWriter{}
 
 // indentf prints a (possibly multi-line) message, prefixed by the indent.
 // nolint(unparam)
-func indentf(indent string, format string, args ...interface{}) {
+func indentf(w io.Writer, indent string, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	for _, line := range strings.Split(msg, "\n") {
-		fmt.Printf("%s%s\n", indent, line)
+		must.Int(fmt.Fprintf(w, "%s%s\n", indent, line))
 	}
 }
 
-// ShouldContinue ...
-func (t *TapWriter) ShouldContinue() bool {
-	return true
+// output returns the writer for TAP output.
+func (t *TapWriter) output() io.Writer {
+	if t.out == nil {
+		return os.Stdout
+	}
+
+	return t.out
 }
 
 // Failed ...
@@ -42,63 +50,175 @@ func (t *TapWriter) Failed() bool {
 }
 
 // NewDocument ...
-func (t *TapWriter) NewDocument(desc string) Closer {
+func (t *TapWriter) NewDocument(desc string) DocumentHandle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	d := &tapDocument{
+		writer: t,
+		output: t.serial.open(),
+	}
+
 	// It's not obvious how TAP separates test runs into suites
 	// (maybe it doesn't?). Let's stuff a newline in there so at
 	// least it's visually distinguished.
 	if t.docCount == 0 {
-		fmt.Printf("TAP version 13\n")
+		must.Int(fmt.Fprintf(d.out(), "TAP version 13\n"))
 	} else {
-		fmt.Printf("\nTAP version 13\n")
+		must.Int(fmt.Fprintf(d.out(), "\nTAP version 13\n"))
 	}
 
 	t.docCount++
-	t.stepCount = 0
 
-	return CloserFunc(func() {
-		// NOTE, it's a closed interval.
-		fmt.Printf("1..%d\n", t.stepCount)
-	})
+	return d
+}
+
+// tapDocument is the DocumentHandle for a TapWriter document.
+type tapDocument struct {
+	writer *TapWriter
+	output *documentOutput
+
+	stepCount int
+}
+
+var _ DocumentHandle = &tapDocument{}
+
+// out returns the writer for the document output. The caller must
+// hold the writer lock.
+func (d *tapDocument) out() io.Writer {
+	t := d.writer
+	return t.serial.writer(t.output(), d.output)
+}
+
+// Close ...
+func (d *tapDocument) Close() {
+	t := d.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// NOTE, it's a closed interval.
+	must.Int(fmt.Fprintf(d.out(), "1..%d\n", d.stepCount))
+
+	t.serial.close(t.output(), d.output)
+}
+
+// ShouldContinue ...
+func (d *tapDocument) ShouldContinue() bool {
+	return true
+}
+
+// SetProperties ...
+func (d *tapDocument) SetProperties(props map[string]interface{}) {
+	d.writer.lock.Lock()
+	defer d.writer.lock.Unlock()
+
+	for _, line := range formatProperties(props) {
+		indentf(d.out(), "# ", "%s", line)
+	}
 }
 
 // NewStep ...
-func (t *TapWriter) NewStep(desc string) Closer {
-	stepNum := t.stepCount + 1
-	t.stepCount++
-
-	return CloserFunc(func() {
-		switch {
-		case len(t.stepErrors) > 0:
-			fmt.Printf("not ok %d - %s\n", stepNum, desc)
-		case len(t.stepSkips) > 0:
-			fmt.Printf("ok %d - %s # skip\n", stepNum, desc)
-		default:
-			fmt.Printf("ok %d - %s\n", stepNum, desc)
-		}
+func (d *tapDocument) NewStep(desc string) StepHandle {
+	return &tapStep{
+		doc:         d,
+		desc:        desc,
+		start:       time.Now(),
+		diagnostics: map[string]interface{}{},
+	}
+}
+
+// tapStep is the StepHandle for a TapWriter step.
+type tapStep struct {
+	doc *tapDocument
+
+	desc  string
+	start time.Time
+	end   time.Time
+
+	errors      []result.Result
+	skips       []result.Result
+	diagnostics map[string]interface{}
+}
+
+var _ TimedStepHandle = &tapStep{}
+
+// SetTimes ...
+func (s *tapStep) SetTimes(start time.Time, end time.Time) {
+	s.doc.writer.lock.Lock()
+	defer s.doc.writer.lock.Unlock()
+
+	s.start = start
+	s.end = end
+}
+
+// Close ...
+func (s *tapStep) Close() {
+	d := s.doc
+
+	d.writer.lock.Lock()
+	defer d.writer.lock.Unlock()
 
-		if len(t.stepErrors) > 0 {
-			indent := "  "
-			indentf(indent, "---")
-			indentf(indent, string(must.Bytes(yaml.Marshal(t.stepErrors))))
-			indentf(indent, "...")
+	out := d.out()
+
+	// Number steps as they finish, so that the test
+	// numbers are in sequence even if steps overlap.
+	d.stepCount++
+	stepNum := d.stepCount
+
+	switch {
+	case len(s.errors) > 0:
+		must.Int(fmt.Fprintf(out, "not ok %d - %s\n", stepNum, s.desc))
+	case len(s.skips) > 0:
+		must.Int(fmt.Fprintf(out, "ok %d - %s # skip\n", stepNum, s.desc))
+	default:
+		must.Int(fmt.Fprintf(out, "ok %d - %s\n", stepNum, s.desc))
+	}
+
+	if len(s.errors) > 0 {
+		indent := "  "
+		indentf(out, indent, "---")
+		indentf(out, indent, string(must.Bytes(yaml.Marshal(s.errors))))
+		indentf(out, indent, "...")
+
+		// Show diagnostics to help explain the failure.
+		for _, line := range formatDiagnostics(s.diagnostics) {
+			indentf(out, "# ", "%s", line)
 		}
+	}
 
-		t.stepErrors = nil
-	})
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+
+	indentf(out, "# ", "duration: %s", formatElapsed(s.end.Sub(s.start)))
+}
+
+// Diagnose ...
+func (s *tapStep) Diagnose(key string, value interface{}) {
+	s.doc.writer.lock.Lock()
+	defer s.doc.writer.lock.Unlock()
+
+	s.diagnostics[key] = value
 }
 
 // Update ...
-func (t *TapWriter) Update(results ...result.Result) {
+func (s *tapStep) Update(results ...result.Result) {
+	d := s.doc
+
+	d.writer.lock.Lock()
+	defer d.writer.lock.Unlock()
+
 	for _, r := range results {
 		switch r.Severity {
 		case result.SeverityNone:
-			indentf("# ", r.Message)
+			indentf(d.out(), "# ", r.Message)
 		case result.SeveritySkip:
-			indentf(fmt.Sprintf("# %s - ", string(r.Severity)), r.Message)
-			t.stepSkips = append(t.stepSkips, r)
+			indentf(d.out(), fmt.Sprintf("# %s - ", string(r.Severity)), r.Message)
+			s.skips = append(s.skips, r)
 		default:
-			indentf(fmt.Sprintf("# %s - ", string(r.Severity)), r.Message)
-			t.stepErrors = append(t.stepErrors, r)
+			indentf(d.out(), fmt.Sprintf("# %s - ", string(r.Severity)), r.Message)
+			s.errors = append(s.errors, r)
 		}
 	}
 }
@@ -0,0 +1,127 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DiagnosticCheck is the Step.Diagnostics key for the CheckStats
+// of the check that was run in the step.
+const DiagnosticCheck = "check"
+
+// CheckStats describes how a check was polled until it converged.
+type CheckStats struct {
+	// Attempts is the number of times the check was evaluated.
+	Attempts int `json:"attempts"`
+	// Elapsed is the time spent polling the check.
+	Elapsed time.Duration `json:"elapsed"`
+	// Converged is whether the check passed (or skipped)
+	// before the timeout.
+	Converged bool `json:"converged"`
+}
+
+func (c CheckStats) String() string {
+	if c.Converged {
+		return fmt.Sprintf("converged after %d attempts in %s",
+			c.Attempts, formatElapsed(c.Elapsed))
+	}
+
+	return fmt.Sprintf("did not converge after %d attempts in %s",
+		c.Attempts, formatElapsed(c.Elapsed))
+}
+
+// checkStatsFrom converts a generic diagnostic value (e.g. one that
+// was decoded from JSON) to CheckStats.
+func checkStatsFrom(v interface{}) (CheckStats, bool) {
+	if stats, ok := v.(CheckStats); ok {
+		return stats, true
+	}
+
+	var stats CheckStats
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return stats, false
+	}
+
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return stats, false
+	}
+
+	return stats, true
+}
+
+// formatElapsed formats a duration to millisecond precision.
+func formatElapsed(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// stepDuration returns the duration of the step, or zero if the
+// step didn't finish.
+func stepDuration(s *Step) time.Duration {
+	if s.Start.IsZero() || s.End.IsZero() {
+		return 0
+	}
+
+	return s.End.Sub(s.Start)
+}
+
+// TimingSummary returns lines that summarize the n slowest steps,
+// and the n checks that spent the longest time polling, in the
+// given test documents.
+func TimingSummary(docs []*Document, n int) []string {
+	type timing struct {
+		name     string
+		duration time.Duration
+		check    CheckStats
+	}
+
+	var steps []timing
+	var checks []timing
+
+	for _, d := range docs {
+		for i, s := range d.Steps {
+			name := fmt.Sprintf("%s: Step %d: %s", d.Description, i, s.Description)
+
+			steps = append(steps, timing{name: name, duration: stepDuration(s)})
+
+			if v, ok := s.Diagnostics[DiagnosticCheck]; ok {
+				if stats, ok := checkStatsFrom(v); ok {
+					checks = append(checks, timing{name: name, duration: stats.Elapsed, check: stats})
+				}
+			}
+		}
+	}
+
+	slowest := func(timings []timing) []timing {
+		sort.SliceStable(timings, func(i, j int) bool {
+			return timings[i].duration > timings[j].duration
+		})
+
+		if len(timings) > n {
+			return timings[:n]
+		}
+
+		return timings
+	}
+
+	var lines []string
+
+	if len(steps) > 0 {
+		lines = append(lines, "Slowest steps:")
+		for _, t := range slowest(steps) {
+			lines = append(lines, fmt.Sprintf("  %10s  %s", formatElapsed(t.duration), t.name))
+		}
+	}
+
+	if len(checks) > 0 {
+		lines = append(lines, "Slowest checks:")
+		for _, t := range slowest(checks) {
+			lines = append(lines, fmt.Sprintf("  %10s  %s (%s)", formatElapsed(t.duration), t.name, t.check))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,75 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jpeach/modden/pkg/driver"
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCheckStats(t *testing.T) {
+	c := driver.NewRegoDriver()
+
+	passing := ast.MustParseModule(`package test
+error[msg] { false; msg := "unreachable" }`)
+
+	results, stats, err := runCheck(c, passing, time.Second, nil, rego.ParsedModule(passing))
+	require.NoError(t, err)
+	assert.Empty(t, results)
+	assert.Equal(t, 1, stats.Attempts)
+	assert.True(t, stats.Converged)
+
+	failing := ast.MustParseModule(`package test
+error[msg] { msg := "always fails" }`)
+
+	results, stats, err = runCheck(c, failing, time.Second, nil, rego.ParsedModule(failing))
+	require.NoError(t, err)
+	assert.True(t, result.Contains(results, result.SeverityError))
+	assert.Greater(t, stats.Attempts, 1)
+	assert.False(t, stats.Converged)
+	assert.GreaterOrEqual(t, int64(stats.Elapsed), int64(time.Second))
+}
+
+func TestTimingSummary(t *testing.T) {
+	start := time.Now()
+
+	step := func(desc string, d time.Duration, diags map[string]interface{}) *Step {
+		return &Step{Description: desc, Start: start, End: start.Add(d), Diagnostics: diags}
+	}
+
+	docs := []*Document{{
+		Description: "a.yaml",
+		Steps: []*Step{
+			step("fast", time.Millisecond, nil),
+			step("check", 3*time.Second, map[string]interface{}{
+				DiagnosticCheck: CheckStats{Attempts: 5, Elapsed: 2 * time.Second, Converged: true},
+			}),
+		},
+	}, {
+		Description: "b.yaml",
+		Steps: []*Step{
+			step("slow", 5*time.Second, nil),
+			// A check decoded from JSON.
+			step("json check", time.Second, map[string]interface{}{
+				DiagnosticCheck: map[string]interface{}{"attempts": 2.0, "elapsed": 1e9, "converged": false},
+			}),
+		},
+	}}
+
+	assert.Equal(t, []string{
+		"Slowest steps:",
+		"          5s  b.yaml: Step 0: slow",
+		"          3s  a.yaml: Step 1: check",
+		"Slowest checks:",
+		"          2s  a.yaml: Step 1: check (converged after 5 attempts in 2s)",
+		"          1s  b.yaml: Step 1: json check (did not converge after 2 attempts in 1s)",
+	}, TimingSummary(docs, 2))
+
+	assert.Empty(t, TimingSummary(nil, 5))
+}
@@ -2,11 +2,19 @@ package test
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/jpeach/modden/pkg/must"
 	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 type leader string
@@ -16,14 +24,40 @@ const (
 	boxBranch   = "├─"
 	boxVertical = "│ "
 	boxLeft     = "└─"
+	boxEmpty    = "  "
 
 	// tabPrintf leaders are boxing characters with a bit of
 	// fixed breathing space.
 	branchLeader leader = boxBranch + " "
 	elbowLeader  leader = boxLeft + " "
 	emptyLeader  leader = ""
+
+	// timestampFormat is the format of the timestamp that
+	// prefixes each line.
+	timestampFormat = "15:04:05.0000"
+
+	// timestampWidth is the number of columns taken by the
+	// timestamp and the following tab.
+	timestampWidth = 16
+
+	// minWrapWidth is the narrowest that messages are wrapped.
+	minWrapWidth = 20
 )
 
+// continuation returns the leader for the subsequent lines of a
+// multi-line message. Branches continue the vertical line down to
+// the next entry, but elbows are the last entry, so nothing follows.
+func (l leader) continuation() string {
+	switch l {
+	case branchLeader:
+		return boxVertical + " "
+	case elbowLeader:
+		return boxEmpty + " "
+	default:
+		return ""
+	}
+}
+
 func formatIndent(n int) string {
 	b := strings.Builder{}
 	b.Grow(n * len(boxVertical))
@@ -61,43 +95,176 @@ func formatFailCounters(fails map[result.Severity]int) string {
 	return b.String()
 }
 
+// escapeSequence matches the terminal escape sequences that are
+// used to color text.
+var escapeSequence = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+// textWidth returns the number of columns that the text takes,
+// ignoring any color escape sequences.
+func textWidth(text string) int {
+	return utf8.RuneCountInString(escapeSequence.ReplaceAllString(text, ""))
+}
+
+// wrapText splits each line of the message into lines that are
+// no wider than width, breaking at spaces. Words that are wider
+// than width are not broken. If width is zero, lines are not
+// wrapped.
+func wrapText(msg string, width int) []string {
+	var lines []string
+
+	for _, line := range strings.Split(msg, "\n") {
+		if width <= 0 || textWidth(line) <= width {
+			lines = append(lines, line)
+			continue
+		}
+
+		current := ""
+		for _, word := range strings.Split(line, " ") {
+			switch {
+			case current == "":
+				current = word
+			case textWidth(current)+1+textWidth(word) > width:
+				lines = append(lines, current)
+				current = word
+			default:
+				current = current + " " + word
+			}
+		}
+
+		lines = append(lines, current)
+	}
+
+	return lines
+}
+
+// formatTree formats a (possibly multi-line) message at the given
+// indent. The leader is formatted on the first line, and subsequent
+// lines are aligned with the first line's text. If width is
+// non-zero, the message is wrapped to fit in the given width.
+func formatTree(indent int, leader leader, width int, msg string) []string {
+	prefix := formatIndent(indent) + string(leader)
+	next := formatIndent(indent) + leader.continuation()
+
+	wrap := 0
+	if width > 0 {
+		wrap = width - timestampWidth - utf8.RuneCountInString(prefix)
+		if wrap < minWrapWidth {
+			wrap = 0
+		}
+	}
+
+	lines := wrapText(msg, wrap)
+	for n := range lines {
+		if n == 0 {
+			lines[n] = prefix + lines[n]
+		} else {
+			lines[n] = next + lines[n]
+		}
+	}
+
+	return lines
+}
+
+// TerminalWidth returns the width of the terminal on standard
+// output, or zero if standard output is not a terminal.
+func TerminalWidth() int {
+	width, _, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		return 0
+	}
+
+	return width
+}
+
+// ColorEnabled returns whether standard output should be colored.
+// Color is disabled if standard output is not a terminal, or if the
+// NO_COLOR environment variable is set (see https://no-color.org).
+func ColorEnabled() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+
+	return !color.NoColor
+}
+
+// treeLine is a line of buffered tree output.
+type treeLine struct {
+	text string
+	info bool
+}
+
 // TreeWriter is a Recorder that write test results to a standard
 // output in a tree notation.
 type TreeWriter struct {
-	indent    int
-	docCount  int
-	stepCount int
+	// Verbose shows the informational results of passing steps.
+	// Otherwise, only the results of failing or skipped steps
+	// are shown.
+	Verbose bool
+	// Color enables colored output.
+	Color bool
+	// Width is the width to wrap output to. If it is zero,
+	// output is not wrapped.
+	Width int
+
+	out io.Writer
 
-	stepErrors map[result.Severity]int
-	allErrors  map[result.Severity]int
+	lock     sync.Mutex
+	indent   int
+	docCount int
+	serial   serialOutput
 }
 
 var _ Recorder = &TreeWriter{}
 
-func tabPrintf(indent int, leader leader, format string, args ...interface{}) {
-	timestamp := time.Now().Format("15:04:05.0000")
-	msg := fmt.Sprintf(format, args...)
-	lines := strings.Split(msg, "\n")
-
-	for n, line := range lines {
-		// Format the leader only on the first output line,
-		// replacing it with an extra indent on subsequent
-		// lines. This makes branchLeader entries look better,
-		// but will horrendously munge elbowLeader ones (the
-		// logic needs to be reversed).
-		if n == 0 {
-			fmt.Printf("%s\t%s%s%s\n",
-				timestamp, formatIndent(indent), leader, line)
-		} else {
-			fmt.Printf("%s\t%s %s\n",
-				timestamp, formatIndent(indent+1), line)
-		}
+// NewTreeWriter returns a TreeWriter for standard output that is
+// colored and wrapped according to the terminal.
+func NewTreeWriter(verbose bool) *TreeWriter {
+	return &TreeWriter{
+		Verbose: verbose,
+		Color:   ColorEnabled(),
+		Width:   TerminalWidth(),
 	}
 }
 
-// ShouldContinue ...
-func (t *TreeWriter) ShouldContinue() bool {
-	return true
+// paint colors the text if color is enabled.
+func (t *TreeWriter) paint(text string, attrs ...color.Attribute) string {
+	if !t.Color || len(attrs) == 0 {
+		return text
+	}
+
+	c := color.New(attrs...)
+	c.EnableColor()
+
+	return c.Sprint(text)
+}
+
+// formatLines formats a message as timestamped tree lines.
+func (t *TreeWriter) formatLines(indent int, leader leader, msg string) []string {
+	timestamp := time.Now().Format(timestampFormat)
+	lines := formatTree(indent, leader, t.Width, msg)
+
+	for n := range lines {
+		lines[n] = fmt.Sprintf("%s\t%s", timestamp, lines[n])
+	}
+
+	return lines
+}
+
+// output returns the writer for tree output.
+func (t *TreeWriter) output() io.Writer {
+	if t.out == nil {
+		return os.Stdout
+	}
+
+	return t.out
+}
+
+// tabPrintf prints a (possibly multi-line) message at the given
+// indent. The caller must hold the lock.
+func (t *TreeWriter) tabPrintf(w io.Writer, indent int, leader leader, format string, args ...interface{}) {
+	for _, line := range t.formatLines(indent, leader, fmt.Sprintf(format, args...)) {
+		must.Int(fmt.Fprintln(w, line))
+	}
 }
 
 // Failed ...
@@ -106,65 +273,274 @@ func (t *TreeWriter) Failed() bool {
 }
 
 // NewDocument ...
-func (t *TreeWriter) NewDocument(desc string) Closer {
+func (t *TreeWriter) NewDocument(desc string) DocumentHandle {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	d := &treeDocument{
+		writer: t,
+		output: t.serial.open(),
+		errors: map[result.Severity]int{},
+	}
+
 	if t.docCount > 0 {
-		fmt.Printf("\n")
+		must.Int(fmt.Fprintln(d.out()))
 	}
 
-	tabPrintf(t.indent, emptyLeader, "Running: %s", desc)
+	d.printf(t.indent, emptyLeader, "Running: %s", t.paint(desc, color.Bold))
 
 	t.docCount++
-	t.stepCount = 0
-	t.allErrors = map[result.Severity]int{}
-
-	return CloserFunc(func() {
-		switch {
-		case t.allErrors[result.SeveritySkip] > 0:
-			tabPrintf(t.indent, elbowLeader, "Skipped after %d steps", t.stepCount)
-		case (t.allErrors[result.SeverityFatal] + t.allErrors[result.SeverityError]) > 0:
-			tabPrintf(t.indent, elbowLeader,
-				"Failed with %s ", formatFailCounters(t.allErrors))
-		default:
-			tabPrintf(t.indent, elbowLeader, "Pass with %d steps OK", t.stepCount)
-		}
-	})
+
+	return d
+}
+
+// treeDocument is the DocumentHandle for a TreeWriter document.
+type treeDocument struct {
+	writer *TreeWriter
+	output *documentOutput
+
+	stepCount int
+	errors    map[result.Severity]int
+
+	// lastPrinted is the step that most recently printed
+	// output, or nil if the output was not for a step.
+	lastPrinted *treeStep
+}
+
+var _ DocumentHandle = &treeDocument{}
+
+// out returns the writer for the document output. The caller must
+// hold the writer lock.
+func (d *treeDocument) out() io.Writer {
+	t := d.writer
+	return t.serial.writer(t.output(), d.output)
+}
+
+// printf prints a (possibly multi-line) message to the document
+// output. The caller must hold the writer lock.
+func (d *treeDocument) printf(indent int, leader leader, format string, args ...interface{}) {
+	d.writer.tabPrintf(d.out(), indent, leader, format, args...)
+}
+
+// Close ...
+func (d *treeDocument) Close() {
+	t := d.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch {
+	case d.errors[result.SeveritySkip] > 0:
+		d.printf(t.indent, elbowLeader, "%s",
+			t.paint(fmt.Sprintf("Skipped after %d steps", d.stepCount), color.FgYellow))
+	case (d.errors[result.SeverityFatal] + d.errors[result.SeverityError]) > 0:
+		d.printf(t.indent, elbowLeader, "%s",
+			t.paint(fmt.Sprintf("Failed with %s", formatFailCounters(d.errors)), color.FgRed, color.Bold))
+	default:
+		d.printf(t.indent, elbowLeader, "%s",
+			t.paint(fmt.Sprintf("Pass with %d steps OK", d.stepCount), color.FgGreen))
+	}
+
+	d.lastPrinted = nil
+
+	t.serial.close(t.output(), d.output)
+}
+
+// ShouldContinue ...
+func (d *treeDocument) ShouldContinue() bool {
+	return true
+}
+
+// SetProperties ...
+func (d *treeDocument) SetProperties(props map[string]interface{}) {
+	t := d.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for _, line := range formatProperties(props) {
+		d.printf(t.indent, branchLeader, "%s", line)
+	}
+
+	d.lastPrinted = nil
 }
 
 // NewStep ...
-func (t *TreeWriter) NewStep(desc string) Closer {
-	tabPrintf(t.indent, branchLeader, "Step %d: %s", t.stepCount, desc)
-
-	t.indent++
-	t.stepCount++
-	t.stepErrors = map[result.Severity]int{}
-
-	return CloserFunc(func() {
-		switch {
-		case t.stepErrors[result.SeveritySkip] > 0:
-			tabPrintf(t.indent, elbowLeader, "Skipped")
-		case (t.stepErrors[result.SeverityFatal] + t.stepErrors[result.SeverityError]) > 0:
-			tabPrintf(t.indent, elbowLeader,
-				"Failed with %s ", formatFailCounters(t.stepErrors))
-		default:
-			tabPrintf(t.indent, elbowLeader, "Pass")
+func (d *treeDocument) NewStep(desc string) StepHandle {
+	t := d.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := &treeStep{
+		doc:         d,
+		num:         d.stepCount,
+		desc:        desc,
+		start:       time.Now(),
+		errors:      map[result.Severity]int{},
+		diagnostics: map[string]interface{}{},
+	}
+
+	d.stepCount++
+	d.printHeader(s)
+
+	return s
+}
+
+// printHeader prints the step header. The caller must hold the
+// writer lock.
+func (d *treeDocument) printHeader(s *treeStep) {
+	d.printf(d.writer.indent, branchLeader, "Step %d: %s", s.num, s.desc)
+	d.lastPrinted = s
+}
+
+// treeStep is the StepHandle for a TreeWriter step.
+type treeStep struct {
+	doc *treeDocument
+
+	num   int
+	desc  string
+	start time.Time
+	end   time.Time
+
+	lines       []treeLine
+	errors      map[result.Severity]int
+	diagnostics map[string]interface{}
+}
+
+var _ TimedStepHandle = &treeStep{}
+
+// SetTimes ...
+func (s *treeStep) SetTimes(start time.Time, end time.Time) {
+	s.doc.writer.lock.Lock()
+	defer s.doc.writer.lock.Unlock()
+
+	s.start = start
+	s.end = end
+}
+
+// printf records a message for the step. Unless the writer is
+// verbose, the messages are buffered until the step is closed, so
+// that informational messages can be hidden if the step passes. The
+// caller must hold the writer lock.
+func (s *treeStep) printf(info bool, format string, args ...interface{}) {
+	d := s.doc
+	t := d.writer
+
+	if t.Verbose {
+		// If another step printed since this step did,
+		// repeat the header so the output is attributed
+		// to the right step.
+		if d.lastPrinted != s {
+			d.printHeader(s)
+		}
+
+		d.printf(t.indent+1, branchLeader, format, args...)
+		return
+	}
+
+	for _, line := range t.formatLines(t.indent+1, branchLeader, fmt.Sprintf(format, args...)) {
+		s.lines = append(s.lines, treeLine{text: line, info: info})
+	}
+}
+
+// flush prints the buffered lines of the step. If showInfo is
+// false, informational lines are dropped. The caller must hold
+// the writer lock.
+func (s *treeStep) flush(showInfo bool) {
+	d := s.doc
+
+	for _, l := range s.lines {
+		if l.info && !showInfo {
+			continue
 		}
 
-		t.indent--
-		for k, v := range t.stepErrors {
-			t.allErrors[k] = t.allErrors[k] + v
+		if d.lastPrinted != s {
+			d.printHeader(s)
+		}
+
+		must.Int(fmt.Fprintln(d.out(), l.text))
+	}
+
+	s.lines = nil
+}
+
+// Close ...
+func (s *treeStep) Close() {
+	d := s.doc
+	t := d.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if s.end.IsZero() {
+		s.end = time.Now()
+	}
+
+	elapsed := formatElapsed(s.end.Sub(s.start))
+	indent := t.indent + 1
+
+	skipped := s.errors[result.SeveritySkip] > 0
+	failed := (s.errors[result.SeverityFatal] + s.errors[result.SeverityError]) > 0
+
+	s.flush(skipped || failed)
+
+	if d.lastPrinted != s {
+		d.printHeader(s)
+	}
+
+	switch {
+	case skipped:
+		d.printf(indent, elbowLeader, "%s (%s)",
+			t.paint("Skipped", color.FgYellow), elapsed)
+	case failed:
+		// Show diagnostics to help explain the failure.
+		for _, line := range formatDiagnostics(s.diagnostics) {
+			d.printf(indent, branchLeader, "%s", t.paint(line, color.Faint))
 		}
-	})
+
+		d.printf(indent, elbowLeader, "%s (%s)",
+			t.paint(fmt.Sprintf("Failed with %s", formatFailCounters(s.errors)), color.FgRed),
+			elapsed)
+	default:
+		d.printf(indent, elbowLeader, "%s (%s)",
+			t.paint("Pass", color.FgGreen), elapsed)
+	}
+
+	d.lastPrinted = nil
+
+	for k, v := range s.errors {
+		d.errors[k] = d.errors[k] + v
+	}
+}
+
+// Diagnose ...
+func (s *treeStep) Diagnose(key string, value interface{}) {
+	s.doc.writer.lock.Lock()
+	defer s.doc.writer.lock.Unlock()
+
+	s.diagnostics[key] = value
 }
 
 // Update ...
-func (t *TreeWriter) Update(results ...result.Result) {
+func (s *treeStep) Update(results ...result.Result) {
+	t := s.doc.writer
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
 	for _, r := range results {
 		switch r.Severity {
 		case result.SeverityNone:
-			tabPrintf(t.indent, branchLeader, "%s", r.Message)
+			s.printf(true, "%s", r.Message)
+		case result.SeveritySkip:
+			s.errors[r.Severity]++
+			s.printf(false, "%s: %s",
+				t.paint(strings.ToUpper(string(r.Severity)), color.FgYellow), r.Message)
 		default:
-			t.stepErrors[r.Severity]++
-			tabPrintf(t.indent, branchLeader, "%s: %s", strings.ToUpper(string(r.Severity)), r.Message)
+			s.errors[r.Severity]++
+			s.printf(false, "%s: %s",
+				t.paint(strings.ToUpper(string(r.Severity)), color.FgRed, color.Bold), r.Message)
 		}
 	}
 }
@@ -0,0 +1,102 @@
+package test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jpeach/modden/pkg/result"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapText(t *testing.T) {
+	assert.Equal(t, []string{"short"}, wrapText("short", 0))
+	assert.Equal(t, []string{"one two", "three"}, wrapText("one two three", 8))
+	assert.Equal(t, []string{"a", "b c"}, wrapText("a\nb c", 10))
+
+	// Long words are not broken.
+	assert.Equal(t, []string{"abcdefghij", "k"}, wrapText("abcdefghij k", 5))
+
+	// Color escapes don't take up any width.
+	assert.Equal(t, []string{"\x1b[31mERROR\x1b[0m: one", "two"},
+		wrapText("\x1b[31mERROR\x1b[0m: one two", 10))
+}
+
+func TestFormatTree(t *testing.T) {
+	assert.Equal(t, []string{
+		"│ ├─ first",
+		"│ │  second",
+	}, formatTree(1, branchLeader, 0, "first\nsecond"))
+
+	// Elbows end the branch, so continuation lines
+	// must not have a vertical line.
+	assert.Equal(t, []string{
+		"│ └─ first",
+		"│    second",
+	}, formatTree(1, elbowLeader, 0, "first\nsecond"))
+
+	assert.Equal(t, []string{
+		"first",
+		"second",
+	}, formatTree(0, emptyLeader, 0, "first\nsecond"))
+
+	// The timestamp and prefix take 16 + 3 columns, leaving
+	// 21 columns for the text.
+	assert.Equal(t, []string{
+		"├─ aaaaa bbbbb ccccc",
+		"│  ddddd",
+	}, formatTree(0, branchLeader, 40, "aaaaa bbbbb ccccc ddddd"))
+}
+
+func runTreeSteps(w *TreeWriter) string {
+	out := &bytes.Buffer{}
+	w.out = out
+
+	d := w.NewDocument("test.yaml")
+
+	s := d.NewStep("passing step")
+	s.Update(result.Infof("passing info"))
+	s.Close()
+
+	s = d.NewStep("failing step")
+	s.Update(result.Infof("failing info"), result.Errorf("failed"))
+	s.Diagnose(DiagnosticEvents, []string{"Warning BackOff pod/httpbin"})
+	s.Close()
+
+	d.Close()
+
+	return out.String()
+}
+
+func TestTreeWriterCollapse(t *testing.T) {
+	output := runTreeSteps(&TreeWriter{})
+
+	assert.NotContains(t, output, "passing info")
+	assert.Contains(t, output, "failing info")
+	assert.Contains(t, output, "ERROR: failed")
+	assert.Contains(t, output, "events: Warning BackOff pod/httpbin")
+	assert.Contains(t, output, "Failed with 1 error")
+
+	// The buffered failing step results are still
+	// printed in order.
+	assert.Less(t,
+		strings.Index(output, "failing info"),
+		strings.Index(output, "ERROR: failed"))
+
+	assert.NotContains(t, output, "\x1b[")
+}
+
+func TestTreeWriterVerbose(t *testing.T) {
+	output := runTreeSteps(&TreeWriter{Verbose: true})
+
+	assert.Contains(t, output, "passing info")
+	assert.Contains(t, output, "failing info")
+}
+
+func TestTreeWriterColor(t *testing.T) {
+	output := runTreeSteps(&TreeWriter{Color: true})
+
+	assert.Contains(t, output, "\x1b[31;1mERROR\x1b[0m: failed")
+	assert.Contains(t, output, "\x1b[32mPass\x1b[0m")
+}
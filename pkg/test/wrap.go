@@ -1,6 +1,10 @@
 package test
 
-import "github.com/jpeach/modden/pkg/result"
+import (
+	"time"
+
+	"github.com/jpeach/modden/pkg/result"
+)
 
 // StackRecorders returns a new Recorder that stacks top and next.
 // For each method in the Recorder interface, methods from top will
@@ -9,14 +13,6 @@ func StackRecorders(top Recorder, next Recorder) Recorder {
 	return &wrapRecorder{top, next}
 }
 
-func wrappedCloser(closers []Closer) Closer {
-	return CloserFunc(func() {
-		for _, c := range closers {
-			c.Close()
-		}
-	})
-}
-
 type wrapRecorder struct {
 	top  Recorder
 	next Recorder
@@ -24,35 +20,83 @@ type wrapRecorder struct {
 
 var _ Recorder = &wrapRecorder{}
 
-func (w wrapRecorder) ShouldContinue() bool {
-	return w.top.ShouldContinue() &&
-		w.next.ShouldContinue()
-}
-
 func (w wrapRecorder) Failed() bool {
 	return w.top.Failed() ||
 		w.next.Failed()
 }
 
-func (w wrapRecorder) NewDocument(desc string) Closer {
-	closers := []Closer{
+func (w wrapRecorder) NewDocument(desc string) DocumentHandle {
+	return stackDocuments(
 		w.top.NewDocument(desc),
 		w.next.NewDocument(desc),
+	)
+}
+
+// stackDocuments returns a new DocumentHandle that stacks top and
+// next, in the same way as StackRecorders.
+func stackDocuments(top DocumentHandle, next DocumentHandle) DocumentHandle {
+	return &wrappedDocument{top, next}
+}
+
+// wrappedDocument is the DocumentHandle for a wrapRecorder.
+type wrappedDocument struct {
+	top  DocumentHandle
+	next DocumentHandle
+}
+
+var _ DocumentHandle = &wrappedDocument{}
+
+func (w *wrappedDocument) Close() {
+	w.top.Close()
+	w.next.Close()
+}
+
+func (w *wrappedDocument) ShouldContinue() bool {
+	return w.top.ShouldContinue() &&
+		w.next.ShouldContinue()
+}
+
+func (w *wrappedDocument) NewStep(desc string) StepHandle {
+	return &wrappedStep{
+		top:  w.top.NewStep(desc),
+		next: w.next.NewStep(desc),
 	}
+}
+
+func (w *wrappedDocument) SetProperties(props map[string]interface{}) {
+	w.top.SetProperties(props)
+	w.next.SetProperties(props)
+}
 
-	return wrappedCloser(closers)
+// wrappedStep is the StepHandle for a wrapRecorder.
+type wrappedStep struct {
+	top  StepHandle
+	next StepHandle
 }
 
-func (w wrapRecorder) NewStep(desc string) Closer {
-	closers := []Closer{
-		w.top.NewStep(desc),
-		w.next.NewStep(desc),
+var _ TimedStepHandle = &wrappedStep{}
+
+func (w *wrappedStep) SetTimes(start time.Time, end time.Time) {
+	if t, ok := w.top.(TimedStepHandle); ok {
+		t.SetTimes(start, end)
+	}
+
+	if t, ok := w.next.(TimedStepHandle); ok {
+		t.SetTimes(start, end)
 	}
+}
+
+func (w *wrappedStep) Close() {
+	w.top.Close()
+	w.next.Close()
+}
 
-	return wrappedCloser(closers)
+func (w *wrappedStep) Diagnose(key string, value interface{}) {
+	w.top.Diagnose(key, value)
+	w.next.Diagnose(key, value)
 }
 
-func (w wrapRecorder) Update(results ...result.Result) {
+func (w *wrappedStep) Update(results ...result.Result) {
 	w.top.Update(results...)
 	w.next.Update(results...)
 }
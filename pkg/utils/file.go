@@ -16,6 +16,27 @@ func IsDirPath(path string) bool {
 	return false
 }
 
+// IsSubPath returns true if target is the same path as base, or
+// if it is a path below base.
+func IsSubPath(base string, target string) bool {
+	absBase, err := filepath.Abs(base)
+	if err != nil {
+		return false
+	}
+
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(absBase, absTarget)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 // WalkFiles is a wrapper around filepath.Walk that accepts a path
 // that may be either a file or a directory. In either case, it recurses
 // the path and applied walkFunc to all files that it finds. Hidden
@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSubPath(t *testing.T) {
+	assert.True(t, IsSubPath("fixtures", "fixtures"))
+	assert.True(t, IsSubPath("fixtures", "fixtures/httpbin.yaml"))
+	assert.True(t, IsSubPath("./fixtures/", "fixtures/nested/httpbin.yaml"))
+	assert.True(t, IsSubPath("/tests", "/tests/fixtures/httpbin.yaml"))
+
+	assert.False(t, IsSubPath("fixtures", "fixtures-extra/httpbin.yaml"))
+	assert.False(t, IsSubPath("fixtures/httpbin.yaml", "fixtures"))
+	assert.False(t, IsSubPath("/tests/fixtures", "/tests/smoke.yaml"))
+	assert.False(t, IsSubPath("fixtures", "..fixtures/httpbin.yaml"))
+}
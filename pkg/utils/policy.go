@@ -30,16 +30,49 @@ func ParseModuleFile(filePath string) (*ast.Module, error) {
 // package name is prepended to make the parsed module globally unique.
 // ParseCheckFragment can return nil with no error if the input is empty.
 func ParseCheckFragment(input string) (*ast.Module, error) {
+	return ParseCheckFragmentAt("", 0, input)
+}
+
+// ParseCheckFragmentAt parses a Rego string that was read from the
+// given file, starting at the given line, into a *ast.Module. All
+// the locations in the parsed module, and in any parse errors, refer
+// to the original file and line. If the file name is empty, a unique
+// placeholder name is used. If the start line is not positive, the
+// locations refer to the parsed module, including the prepended
+// package declaration. ParseCheckFragmentAt can return nil with no
+// error if the input is empty.
+//
+// Since a file can hold many fragments, the modules parsed from it
+// share the same file name, and only the package name is unique.
+// Callers that need to key modules uniquely (e.g. to compile them
+// together) must use the package path, not the file name.
+func ParseCheckFragmentAt(fileName string, startLine int, input string) (*ast.Module, error) {
 	// Rego requires a package name to generate any Rules.  Force
-	// a package name that is unique to the fragment.  Note that
-	// we also use this to generate a unique filename placeholder
-	// since Rego internals will sometime use this as a map key.
+	// a package name that is unique to the fragment.  If we don't
+	// have a file name, we also use this to generate a unique
+	// filename placeholder.
 	moduleName := RandomStringN(12)
 
-	m, err := ast.ParseModule(
-		fmt.Sprintf("internal/check/%s", moduleName),
+	if fileName == "" {
+		fileName = fmt.Sprintf("internal/check/%s", moduleName)
+	}
+
+	// The input starts on the line after the package declaration.
+	offset := 0
+	if startLine > 0 {
+		offset = startLine - 2
+	}
+
+	m, err := ast.ParseModule(fileName,
 		fmt.Sprintf("package check.%s\n%s", moduleName, input))
 	if err != nil {
+		if astErrors := AsRegoCompilationErr(err); astErrors != nil {
+			shifted := map[*ast.Location]bool{}
+			for _, e := range astErrors {
+				shiftLocation(e.Location, offset, shifted)
+			}
+		}
+
 		return nil, err
 	}
 
@@ -48,9 +81,56 @@ func ParseCheckFragment(input string) (*ast.Module, error) {
 		return nil, io.EOF
 	}
 
+	if offset != 0 {
+		shiftModuleLocations(m, offset)
+	}
+
 	return m, nil
 }
 
+// shiftLocation moves the location by the given number of rows,
+// unless it has already been shifted. Parsed nodes can share
+// locations, so we need to make sure to move them only once.
+func shiftLocation(loc *ast.Location, offset int, shifted map[*ast.Location]bool) {
+	if loc == nil || shifted[loc] {
+		return
+	}
+
+	loc.Row += offset
+	shifted[loc] = true
+}
+
+// shiftModuleLocations moves all the locations in the module by the
+// given number of rows.
+func shiftModuleLocations(m *ast.Module, offset int) {
+	shifted := map[*ast.Location]bool{}
+
+	ast.NewGenericVisitor(func(x interface{}) bool {
+		switch x := x.(type) {
+		case *ast.Package:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Import:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Rule:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Head:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Expr:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.SomeDecl:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.With:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Term:
+			shiftLocation(x.Location, offset, shifted)
+		case *ast.Comment:
+			shiftLocation(x.Location, offset, shifted)
+		}
+
+		return false
+	}).Walk(m)
+}
+
 // AsRegoTopdownErr attempts to convert this error error to a Rego
 // topdown.Error.
 func AsRegoTopdownErr(err error) *topdown.Error {
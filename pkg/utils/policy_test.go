@@ -2,10 +2,12 @@ package utils
 
 import (
 	"errors"
+	"strings"
 	"testing"
 
 	"github.com/open-policy-agent/opa/topdown"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAsRegoTopdownErr(t *testing.T) {
@@ -17,3 +19,40 @@ func TestAsRegoTopdownErr(t *testing.T) {
 	assert.Equal(t, e, AsRegoTopdownErr(
 		ChainErrors(errors.New("top"), e)))
 }
+
+func TestParseCheckFragmentAt(t *testing.T) {
+	m, err := ParseCheckFragmentAt("test.yaml", 10, `
+error[msg] {
+  msg := "ten"
+}`)
+	require.NoError(t, err)
+
+	assert.Equal(t, "test.yaml", m.Package.Location.File)
+	assert.Equal(t, 9, m.Package.Location.Row)
+
+	require.Len(t, m.Rules, 1)
+	assert.Equal(t, "test.yaml", m.Rules[0].Location.File)
+	assert.Equal(t, 11, m.Rules[0].Location.Row)
+	assert.Equal(t, 12, m.Rules[0].Body[0].Location.Row)
+	assert.Equal(t, 12, m.Rules[0].Body[0].Operand(0).Location.Row)
+
+	_, err = ParseCheckFragmentAt("test.yaml", 20, `
+error[msg] {
+  msg := "twenty"
+`)
+	require.Error(t, err)
+
+	errs := AsRegoCompilationErr(err)
+	require.NotEmpty(t, errs)
+	assert.Equal(t, "test.yaml", errs[0].Location.File)
+	assert.GreaterOrEqual(t, errs[0].Location.Row, 20)
+}
+
+func TestParseCheckFragment(t *testing.T) {
+	m, err := ParseCheckFragment(`x := 1`)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(m.Package.Location.File, "internal/check/"))
+	assert.Equal(t, 1, m.Package.Location.Row)
+	assert.Equal(t, 2, m.Rules[0].Location.Row)
+}
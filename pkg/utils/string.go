@@ -34,6 +34,13 @@ func ContainsString(values []string, wanted string) bool {
 	return false
 }
 
+// CopyStrings duplicates a slice of strings.
+func CopyStrings(src []string) []string {
+	dst := make([]string, len(src))
+	copy(dst, src)
+	return dst
+}
+
 // JoinLines joins the given strings with "\n".
 func JoinLines(lines ...string) string {
 	switch len(lines) {
@@ -71,3 +78,46 @@ func AsStringSlice(val interface{}) ([]string, bool) {
 		return nil, false
 	}
 }
+
+// EditDistance returns the Levenshtein distance between two strings,
+// i.e. the number of single character insertions, deletions or
+// substitutions needed to change a into b.
+func EditDistance(a string, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	// We only need the previous row of the distance matrix.
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+
+	return m
+}
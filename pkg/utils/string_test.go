@@ -21,3 +21,23 @@ func TestJoinLines(t *testing.T) {
 	lines := []string{"one", "two", "three"}
 	assert.Equal(t, strings.Join(lines, "\n"), JoinLines(lines...))
 }
+
+func TestCopyStrings(t *testing.T) {
+	src := []string{"one", "two"}
+	dst := CopyStrings(src)
+
+	assert.Equal(t, src, dst)
+
+	dst[0] = "three"
+	assert.Equal(t, "one", src[0])
+}
+
+func TestEditDistance(t *testing.T) {
+	assert.Equal(t, 0, EditDistance("", ""))
+	assert.Equal(t, 0, EditDistance("same", "same"))
+	assert.Equal(t, 3, EditDistance("", "abc"))
+	assert.Equal(t, 3, EditDistance("abc", ""))
+	assert.Equal(t, 1, EditDistance("httpbin", "httpbim"))
+	assert.Equal(t, 2, EditDistance("config", "conifg"))
+	assert.Equal(t, 3, EditDistance("kitten", "sitting"))
+}